@@ -11,14 +11,23 @@ import (
 	"image/png"
 	"io"
 	"os"
+	"os/signal"
 	"runtime"
 
 	"github.com/ikawaha/waifu2x.go/engine"
 )
 
 const (
-	modeAnime = "anime"
-	modePhoto = "photo"
+	modeAnime   = "anime"
+	modePhoto   = "photo"
+	modeAnimeY  = "anime_y"
+	modeUKBench = "ukbench"
+)
+
+const (
+	fitExact  = "exact"
+	fitWithin = "within"
+	fitFill   = "fill"
 )
 
 type option struct {
@@ -26,13 +35,21 @@ type option struct {
 	input    string
 	output   string
 	scale    float64
+	width    int
+	height   int
+	fitStr   string
+	depth    int
 	noise    int
+	tta      int
 	parallel int
 	modeStr  string
+	modelDir string
+	alpha    bool
 	verbose  bool
 
 	// option values
 	mode    engine.Mode
+	size    *engine.Size // nil unless the width or the height is specified
 	flagSet *flag.FlagSet
 }
 
@@ -46,11 +63,25 @@ func newOption(w io.Writer, eh flag.ErrorHandling) (o *option) {
 	o.flagSet.SetOutput(w)
 	o.flagSet.StringVar(&o.input, "i", "", "input file (default stdin)")
 	o.flagSet.StringVar(&o.output, "o", "", "output file (default stdout)")
-	o.flagSet.Float64Var(&o.scale, "s", 2.0, "scale multiplier >= 1.0")
+	o.flagSet.Float64Var(&o.scale, "s", 2.0, "scale multiplier >= 1.0, 1 de-noises only and needs -n")
+	o.flagSet.IntVar(&o.width, "width", 0, "output width, the height is derived from the aspect ratio if not specified (overrides -s)")
+	o.flagSet.IntVar(&o.height, "height", 0, "output height, the width is derived from the aspect ratio if not specified (overrides -s)")
+	o.flagSet.StringVar(&o.fitStr, "fit", fitExact, "how the image fits the width and the height, choose from 'exact', 'within' and 'fill'")
+	o.flagSet.IntVar(&o.depth, "depth", 8, "bits per sample of the PNG output, 8 or 16 (GIF output is always 8)")
 	o.flagSet.IntVar(&o.noise, "n", 0, "noise reduction level 0 <= n <= 3")
+	o.flagSet.IntVar(&o.tta, "tta", 1, "test-time augmentation with 2, 4 or 8 flipped and rotated variants, which is slower (1 disables it)")
 	o.flagSet.IntVar(&o.parallel, "p", runtime.GOMAXPROCS(runtime.NumCPU()), "concurrency")
-	o.flagSet.StringVar(&o.modeStr, "m", modeAnime, "waifu2x mode, choose from 'anime' and 'photo'")
+	o.flagSet.StringVar(&o.modeStr, "m", modeAnime, "waifu2x mode, choose from 'anime', 'photo', 'anime_y' and 'ukbench'")
+	o.flagSet.StringVar(&o.modelDir, "d", "", "model directory which has scale2.0x_model.json and noise{1,2,3}_model.json (overrides -m)")
+	o.flagSet.BoolVar(&o.alpha, "a", false, "scale up the alpha channel with the model")
 	o.flagSet.BoolVar(&o.verbose, "v", false, "verbose")
+	o.flagSet.Usage = func() {
+		fmt.Fprintf(w, "Usage of %s:\n", commandName)
+		o.flagSet.PrintDefaults()
+		fmt.Fprintf(w, "Subcommands:\n")
+		fmt.Fprintf(w, "  %s\n    \tconvert a JSON model to the binary model format\n", convertModelCommandName)
+		fmt.Fprintf(w, "  %s\n    \treport the PSNR of the int8 quantized models against the float models\n", quantReportCommandName)
+	}
 	return
 }
 
@@ -65,21 +96,82 @@ func (o *option) parse(args []string) error {
 	if o.scale < 1.0 {
 		return fmt.Errorf("invalid scale, %v > 1", o.scale)
 	}
+	if o.depth != 8 && o.depth != 16 {
+		return fmt.Errorf("invalid depth, it must be 8 or 16")
+	}
 	if o.noise < 0 || o.noise > 3 {
 		return fmt.Errorf("invalid number of noise reduction level, it must be [0,3]")
 	}
+	if !validTTA(o.tta) {
+		return fmt.Errorf("invalid TTA, it must be 1, 2, 4 or 8")
+	}
 	if o.parallel < 1 {
 		return fmt.Errorf("invalid number of parallel, it must be >= 1")
 	}
-	switch o.modeStr {
+	mode, err := parseMode(o.modeStr)
+	if err != nil {
+		return err
+	}
+	o.mode = mode
+	if err := o.parseSize(); err != nil {
+		return err
+	}
+	if o.denoiseOnly() && o.noise == 0 {
+		return fmt.Errorf("nothing to do with scale 1, specify the noise reduction level with -n")
+	}
+	return nil
+}
+
+// parseSize sets the output size if the width or the height is specified.
+func (o *option) parseSize() error {
+	if o.width == 0 && o.height == 0 {
+		return nil
+	}
+	fit, err := parseFit(o.fitStr)
+	if err != nil {
+		return err
+	}
+	if o.width < 0 || o.height < 0 {
+		return fmt.Errorf("invalid size, %dx%d", o.width, o.height)
+	}
+	o.size = &engine.Size{Width: o.width, Height: o.height, Fit: fit}
+	return nil
+}
+
+// validTTA returns whether the number of the TTA variants is supported.
+func validTTA(n int) bool {
+	return n == 1 || n == 2 || n == 4 || n == 8
+}
+
+// denoiseOnly returns whether the image is de-noised without scaling, which needs no scale model.
+func (o *option) denoiseOnly() bool {
+	return o.scale == 1 && o.size == nil
+}
+
+func parseFit(s string) (engine.Fit, error) {
+	switch s {
+	case fitExact:
+		return engine.Exact, nil
+	case fitWithin:
+		return engine.Within, nil
+	case fitFill:
+		return engine.Fill, nil
+	}
+	return 0, fmt.Errorf("invalid fit, choose from 'exact', 'within' or 'fill'")
+}
+
+func parseMode(s string) (engine.Mode, error) {
+	switch s {
 	case modeAnime:
-		o.mode = engine.Anime
+		return engine.Anime, nil
 	case modePhoto:
-		o.mode = engine.Photo
-	default:
-		return fmt.Errorf("invalid mode, choose from 'anime' or 'photo'")
+		return engine.Photo, nil
+	case modeAnimeY:
+		return engine.AnimeY, nil
+	case modeUKBench:
+		return engine.UKBench, nil
 	}
-	return nil
+	return 0, fmt.Errorf("invalid mode, choose from 'anime', 'photo', 'anime_y' or 'ukbench'")
 }
 
 func parseInputImage(file string) ([]byte, string, error) {
@@ -113,20 +205,48 @@ func decodeImage(b []byte, format string) (image.Image, error) {
 	return decoder(bytes.NewReader(b))
 }
 
-func scaleUp(ctx context.Context, w2x *engine.Waifu2x, img image.Image, scale float64, w io.Writer) error {
-	ci, err := w2x.ScaleUp(ctx, img, scale)
-	if err != nil {
-		return err
+func scaleUp(ctx context.Context, w2x *engine.Waifu2x, img image.Image, opt *option, w io.Writer) error {
+	var out image.Image
+	if opt.depth == 16 {
+		var ci engine.ChannelImage16
+		var err error
+		if opt.size != nil {
+			ci, err = w2x.ScaleUpSize16(ctx, img, *opt.size)
+		} else {
+			ci, err = w2x.ScaleUp16(ctx, img, opt.scale)
+		}
+		if err != nil {
+			return err
+		}
+		out = ci.ImageNRGBA64()
+	} else {
+		var ci engine.ChannelImage
+		var err error
+		if opt.size != nil {
+			ci, err = w2x.ScaleUpSize(ctx, img, *opt.size)
+		} else {
+			ci, err = w2x.ScaleUp(ctx, img, opt.scale)
+		}
+		if err != nil {
+			return err
+		}
+		rgba := ci.ImageRGBA()
+		out = &rgba
 	}
-	rgba := ci.ImageRGBA()
-	if err := png.Encode(w, &rgba); err != nil {
+	if err := png.Encode(w, out); err != nil {
 		return fmt.Errorf("output error: %w", err)
 	}
 	return nil
 }
 
-func scaleUpGIF(ctx context.Context, w2x *engine.Waifu2x, img *gif.GIF, scale float64, w io.Writer) error {
-	g, err := w2x.ScaleUpGIF(ctx, img, scale)
+func scaleUpGIF(ctx context.Context, w2x *engine.Waifu2x, img *gif.GIF, opt *option, w io.Writer) error {
+	var g *gif.GIF
+	var err error
+	if opt.size != nil {
+		g, err = w2x.ScaleUpGIFSize(ctx, img, *opt.size)
+	} else {
+		g, err = w2x.ScaleUpGIF(ctx, img, opt.scale)
+	}
 	if err != nil {
 		return err
 	}
@@ -136,8 +256,30 @@ func scaleUpGIF(ctx context.Context, w2x *engine.Waifu2x, img *gif.GIF, scale fl
 	return nil
 }
 
+func loadModelSet(dir string, mode engine.Mode, noise int) (*engine.ModelSet, error) {
+	if dir != "" {
+		return engine.LoadModelSetDir(dir, noise)
+	}
+	return engine.NewAssetModelSet(mode, noise)
+}
+
+func loadNoiseModelSet(dir string, mode engine.Mode, noise int) (*engine.ModelSet, error) {
+	if dir != "" {
+		return engine.LoadNoiseModelSetDir(dir, noise)
+	}
+	return engine.NewAssetNoiseModelSet(mode, noise)
+}
+
 // Run executes the waifu2x command.
 func Run(args []string) error {
+	if len(args) > 0 {
+		switch args[0] {
+		case convertModelCommandName:
+			return runConvertModel(args[1:])
+		case quantReportCommandName:
+			return runQuantReport(args[1:])
+		}
+	}
 	opt := newOption(os.Stderr, flag.ExitOnError)
 	if err := opt.parse(args); err != nil {
 		return err
@@ -147,9 +289,19 @@ func Run(args []string) error {
 		return fmt.Errorf("input error: %w", err)
 	}
 
-	w2x, err := engine.NewWaifu2x(opt.mode, opt.noise, []engine.Option{
+	load := loadModelSet
+	if opt.denoiseOnly() {
+		load = loadNoiseModelSet
+	}
+	models, err := load(opt.modelDir, opt.mode, opt.noise)
+	if err != nil {
+		return err
+	}
+	w2x, err := engine.NewWaifu2xModelSet(models, []engine.Option{
 		engine.Verbose(opt.verbose),
 		engine.Parallel(opt.parallel),
+		engine.ScaleAlpha(opt.alpha),
+		engine.TTA(opt.tta),
 		engine.LogOutput(os.Stderr),
 	}...)
 	if err != nil {
@@ -164,16 +316,18 @@ func Run(args []string) error {
 		defer fp.Close()
 		w = fp
 	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
 	if format != "gif" {
 		img, err := decodeImage(b, format)
 		if err != nil {
 			return err
 		}
-		return scaleUp(context.TODO(), w2x, img, opt.scale, w)
+		return scaleUp(ctx, w2x, img, opt, w)
 	}
 	img, err := gif.DecodeAll(bytes.NewReader(b))
 	if err != nil {
 		return err
 	}
-	return scaleUpGIF(context.TODO(), w2x, img, opt.scale, w)
+	return scaleUpGIF(ctx, w2x, img, opt, w)
 }
@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ikawaha/waifu2x.go/engine"
+)
+
+const convertModelCommandName = `convert-model`
+
+type convertModelOption struct {
+	// flagSet args
+	input  string
+	output string
+
+	flagSet *flag.FlagSet
+}
+
+func newConvertModelOption(w io.Writer, eh flag.ErrorHandling) (o *convertModelOption) {
+	o = &convertModelOption{
+		flagSet: flag.NewFlagSet(commandName+" "+convertModelCommandName, eh),
+	}
+	// option settings
+	o.flagSet.SetOutput(w)
+	o.flagSet.StringVar(&o.input, "i", "", "input JSON model file (default stdin)")
+	o.flagSet.StringVar(&o.output, "o", "", "output binary model file (default stdout)")
+	return
+}
+
+func (o *convertModelOption) parse(args []string) error {
+	if err := o.flagSet.Parse(args); err != nil {
+		return err
+	}
+	// validations
+	if nonFlag := o.flagSet.Args(); len(nonFlag) != 0 {
+		return fmt.Errorf("invalid argument: %v", nonFlag)
+	}
+	return nil
+}
+
+// runConvertModel executes the convert-model subcommand which converts a JSON model to the binary model format.
+func runConvertModel(args []string) error {
+	opt := newConvertModelOption(os.Stderr, flag.ExitOnError)
+	if err := opt.parse(args); err != nil {
+		return err
+	}
+	r := os.Stdin
+	if opt.input != "" {
+		var err error
+		r, err = os.Open(opt.input)
+		if err != nil {
+			return fmt.Errorf("input error: %w", err)
+		}
+		defer r.Close()
+	}
+	m, err := engine.LoadModel(r)
+	if err != nil {
+		return fmt.Errorf("input error: %w", err)
+	}
+	var b bytes.Buffer
+	if err := engine.WriteModelBinary(&b, m); err != nil {
+		return err
+	}
+	var w io.Writer = os.Stdout
+	if opt.output != "" {
+		fp, err := os.Create(opt.output)
+		if err != nil {
+			return fmt.Errorf("output file, %w", err)
+		}
+		defer fp.Close()
+		w = fp
+	}
+	if _, err := b.WriteTo(w); err != nil {
+		return fmt.Errorf("output error: %w", err)
+	}
+	return nil
+}
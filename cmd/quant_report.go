@@ -0,0 +1,183 @@
+package cmd
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"os/signal"
+	"runtime"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/ikawaha/waifu2x.go/engine"
+)
+
+const quantReportCommandName = `quant-report`
+
+type quantReportOption struct {
+	// flagSet args
+	input       string
+	calibration string
+	scale       float64
+	noise       int
+	parallel    int
+	modeStr     string
+	modelDir    string
+
+	// option values
+	mode    engine.Mode
+	flagSet *flag.FlagSet
+}
+
+func newQuantReportOption(w io.Writer, eh flag.ErrorHandling) (o *quantReportOption) {
+	o = &quantReportOption{
+		flagSet: flag.NewFlagSet(commandName+" "+quantReportCommandName, eh),
+	}
+	// option settings
+	o.flagSet.SetOutput(w)
+	o.flagSet.StringVar(&o.input, "i", "", "input file to compare the outputs (default stdin)")
+	o.flagSet.StringVar(&o.calibration, "c", "", "comma separated calibration image files (default the input file)")
+	o.flagSet.Float64Var(&o.scale, "s", 2.0, "scale multiplier > 1.0")
+	o.flagSet.IntVar(&o.noise, "n", 0, "noise reduction level 0 <= n <= 3")
+	o.flagSet.IntVar(&o.parallel, "p", runtime.GOMAXPROCS(runtime.NumCPU()), "concurrency")
+	o.flagSet.StringVar(&o.modeStr, "m", modeAnime, "waifu2x mode, choose from 'anime', 'photo', 'anime_y' and 'ukbench'")
+	o.flagSet.StringVar(&o.modelDir, "d", "", "model directory which has scale2.0x_model.json and noise{1,2,3}_model.json (overrides -m)")
+	return
+}
+
+func (o *quantReportOption) parse(args []string) error {
+	if err := o.flagSet.Parse(args); err != nil {
+		return err
+	}
+	// validations
+	if nonFlag := o.flagSet.Args(); len(nonFlag) != 0 {
+		return fmt.Errorf("invalid argument: %v", nonFlag)
+	}
+	if o.scale <= 1.0 {
+		return fmt.Errorf("invalid scale, %v > 1", o.scale)
+	}
+	if o.noise < 0 || o.noise > 3 {
+		return fmt.Errorf("invalid number of noise reduction level, it must be [0,3]")
+	}
+	if o.parallel < 1 {
+		return fmt.Errorf("invalid number of parallel, it must be >= 1")
+	}
+	mode, err := parseMode(o.modeStr)
+	if err != nil {
+		return err
+	}
+	o.mode = mode
+	return nil
+}
+
+func loadImage(file string) (image.Image, error) {
+	b, format, err := parseInputImage(file)
+	if err != nil {
+		return nil, err
+	}
+	return decodeImage(b, format)
+}
+
+// quantVariant is a model set in which some of the models are quantized.
+type quantVariant struct {
+	name string
+	set  engine.ModelSet
+}
+
+// quantVariants returns the model sets in which each model is quantized, and the one in which all the models are quantized.
+func quantVariants(models *engine.ModelSet, samples []image.Image, noise int) ([]quantVariant, error) {
+	var ret []quantVariant
+	all := *models
+	if models.NoiseModel != nil {
+		q, err := engine.QuantizeModel(models.NoiseModel, samples)
+		if err != nil {
+			return nil, err
+		}
+		all.NoiseModel = q
+		ret = append(ret, quantVariant{
+			name: fmt.Sprintf(engine.NoiseModelNameTmpl, noise),
+			set:  engine.ModelSet{Scale2xModel: models.Scale2xModel, NoiseModel: q},
+		})
+	}
+	if models.Scale2xModel != nil {
+		q, err := engine.QuantizeModel(models.Scale2xModel, samples)
+		if err != nil {
+			return nil, err
+		}
+		all.Scale2xModel = q
+		ret = append(ret, quantVariant{
+			name: engine.ScaleModelName,
+			set:  engine.ModelSet{Scale2xModel: q, NoiseModel: models.NoiseModel},
+		})
+	}
+	return append(ret, quantVariant{name: "all", set: all}), nil
+}
+
+func loadCalibrationSamples(files string) ([]image.Image, error) {
+	var ret []image.Image
+	for _, v := range strings.Split(files, ",") {
+		img, err := loadImage(v)
+		if err != nil {
+			return nil, fmt.Errorf("calibration error: %w", err)
+		}
+		ret = append(ret, img)
+	}
+	return ret, nil
+}
+
+// runQuantReport executes the quant-report subcommand which reports the PSNR of the outputs with the int8 quantized models
+// against the outputs with the float models, for each model and for all the models.
+func runQuantReport(args []string) error {
+	opt := newQuantReportOption(os.Stderr, flag.ExitOnError)
+	if err := opt.parse(args); err != nil {
+		return err
+	}
+	img, err := loadImage(opt.input)
+	if err != nil {
+		return fmt.Errorf("input error: %w", err)
+	}
+	samples := []image.Image{img}
+	if opt.calibration != "" {
+		if samples, err = loadCalibrationSamples(opt.calibration); err != nil {
+			return err
+		}
+	}
+	models, err := loadModelSet(opt.modelDir, opt.mode, opt.noise)
+	if err != nil {
+		return err
+	}
+	variants, err := quantVariants(models, samples, opt.noise)
+	if err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	convert := func(set *engine.ModelSet) (engine.ChannelImage, error) {
+		w2x, err := engine.NewWaifu2xModelSet(set, engine.Parallel(opt.parallel))
+		if err != nil {
+			return engine.ChannelImage{}, err
+		}
+		return w2x.ScaleUp(ctx, img, opt.scale)
+	}
+	want, err := convert(models)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "model\tPSNR(dB)\n")
+	for _, v := range variants {
+		got, err := convert(&v.set)
+		if err != nil {
+			return err
+		}
+		psnr, err := engine.PSNR(want, got)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(tw, "%s\t%.2f\n", v.name, psnr)
+	}
+	return tw.Flush()
+}
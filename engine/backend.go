@@ -0,0 +1,45 @@
+package engine
+
+import "fmt"
+
+// Backend is the implementation of the convolution layers.
+type Backend int
+
+const (
+	// Direct computes the convolution pixel by pixel, the default.
+	Direct Backend = iota + 1
+	// GEMM lays out the patches of the input planes im2col-style and computes the convolution
+	// as a cache-blocked matrix multiplication in tiles of 4 output planes with AVX2 and FMA if available,
+	// which is 2-3 times as fast as Direct for the layers of 32-128 planes.
+	GEMM
+	// Winograd computes the 3x3 convolution with the Winograd minimal filtering F(2x2,3x3),
+	// which needs 2.25 times fewer multiplications. The transformed tiles are multiplied as GEMM does,
+	// so it is faster than Direct, but GEMM is usually faster yet. The other layers are computed directly.
+	Winograd
+)
+
+// String returns the name of the backend.
+func (b Backend) String() string {
+	switch b {
+	case Direct:
+		return "direct"
+	case GEMM:
+		return "gemm"
+	case Winograd:
+		return "winograd"
+	}
+	return fmt.Sprintf("Backend(%d)", int(b))
+}
+
+// ConvolutionBackend sets the option that specifies the implementation of the convolution layers.
+// It applies to the layers whose weights are float32, the quantized layers and the layers in half precision
+// keep their own kernels.
+func ConvolutionBackend(b Backend) Option {
+	return func(w *Waifu2x) error {
+		if b < Direct || b > Winograd {
+			return fmt.Errorf("unknown convolution backend: %v", b)
+		}
+		w.backend = b
+		return nil
+	}
+}
@@ -24,64 +24,78 @@ func NewChannelImageWidthHeight(width, height int) ChannelImage {
 	}
 }
 
-// NewChannelImage returns a channel image corresponding to the specified image.
+// NewChannelImage returns a channel image corresponding to the specified image, and whether it is opaque.
+// The pixels are converted to the alpha-premultiplied RGBA of 8 bits as image.RGBA, i.e. the colors of
+// the images with the straight alpha such as image.NRGBA are premultiplied by the alpha.
+// All the image types of the standard library are converted directly, and the others via their color models.
 func NewChannelImage(img image.Image) (ChannelImage, bool, error) {
-	var (
-		b      []uint8
-		opaque bool
-	)
+	r := img.Bounds()
+	if r.Empty() {
+		return ChannelImage{}, false, fmt.Errorf("empty image: %v", r)
+	}
+	ret := ChannelImage{
+		Width:  r.Dx(),
+		Height: r.Dy(),
+		Buffer: make([]uint8, r.Dx()*r.Dy()*4),
+	}
 	switch t := img.(type) {
 	case *image.RGBA:
-		b = t.Pix
-		opaque = t.Opaque()
+		convertRGBA(ret.Buffer, t, r)
 	case *image.NRGBA:
-		b = t.Pix
-		opaque = t.Opaque()
+		convertNRGBA(ret.Buffer, t, r)
+	case *image.RGBA64:
+		convertRGBA64(ret.Buffer, t, r)
+	case *image.NRGBA64:
+		convertNRGBA64(ret.Buffer, t, r)
+	case *image.Gray:
+		convertGray(ret.Buffer, t, r)
+	case *image.Gray16:
+		convertGray16(ret.Buffer, t, r)
+	case *image.CMYK:
+		convertCMYK(ret.Buffer, t, r)
 	case *image.YCbCr:
-		r := t.Rect
-		for y := 0; y < r.Dy(); y++ {
-			for x := 0; x < r.Dx(); x++ {
-				R, G, B, A := t.At(x, y).RGBA()
-				b = append(b, uint8(R>>8), uint8(G>>8), uint8(B>>8), uint8(A>>8))
-			}
-		}
-		opaque = t.Opaque()
+		convertYCbCr(ret.Buffer, t, r)
+	case *image.NYCbCrA:
+		convertNYCbCrA(ret.Buffer, t, r)
 	case *image.Paletted:
-		r := t.Rect
-		for y := 0; y < r.Dy(); y++ {
-			for x := 0; x < r.Dx(); x++ {
-				R, G, B, A := t.At(x, y).RGBA()
-				b = append(b, uint8(R>>8), uint8(G>>8), uint8(B>>8), uint8(A>>8))
-			}
-		}
-		opaque = t.Opaque()
+		convertPaletted(ret.Buffer, t, r)
 	default:
-		return ChannelImage{}, false, fmt.Errorf("unknown image format: %T", t)
+		convertImage(ret.Buffer, img, r)
 	}
-	return ChannelImage{
-		Width:  img.Bounds().Max.X,
-		Height: img.Bounds().Max.Y,
-		Buffer: b,
-	}, opaque, nil
+	return ret, isOpaque(ret.Buffer), nil
 }
 
 // NewDenormalizedChannelImage returns a channel image corresponding to the image plane.
 func NewDenormalizedChannelImage(p ImagePlane) ChannelImage {
 	img := NewChannelImageWidthHeight(p.Width, p.Height)
 	for i := range p.Buffer {
-		v := int(math.Round(float64(p.Buffer[i]) * 255.0))
-		if v < 0 {
-			v = 0
-		} else if v > 255 {
-			v = 255
-		}
-		img.Buffer[i] = uint8(v)
+		img.Buffer[i] = denormalize(p.Buffer[i])
 	}
 	return img
 }
 
-// ImageRGBA converts the channel image to an image.RGBA and return it.
+func denormalize(v float32) uint8 {
+	i := int(math.Round(float64(v) * 255.0))
+	if i < 0 {
+		return 0
+	} else if i > 255 {
+		return 255
+	}
+	return uint8(i)
+}
+
+// ImageRGBA converts the channel image to an image.RGBA and return it, which shares the buffer.
+// The colors greater than the alpha, which the models may output, are clamped to the alpha in the buffer
+// since the colors of an image.RGBA are premultiplied by the alpha.
 func (c ChannelImage) ImageRGBA() image.RGBA {
+	for i := 0; i+4 <= len(c.Buffer); i += 4 {
+		s := c.Buffer[i : i+4 : i+4]
+		for k := 0; k < 3; k++ {
+			if s[k] > s[3] {
+				s[k] = s[3]
+			}
+		}
+	}
 	r := image.Rect(0, 0, c.Width, c.Height)
 	return image.RGBA{
 		Pix:    c.Buffer,
@@ -94,7 +108,7 @@ func (c ChannelImage) ImageRGBA() image.RGBA {
 func (c ChannelImage) ImagePaletted(p color.Palette) *image.Paletted {
 	rgba := c.ImageRGBA()
 	ret := image.NewPaletted(rgba.Bounds(), p)
-	draw.DrawMask(ret, image.Rect(0, 0, ret.Bounds().Max.X, ret.Bounds().Max.Y), &rgba, image.Point{}, nil, image.Point{}, draw.Src)
+	draw.Draw(ret, ret.Bounds(), &rgba, rgba.Rect.Min, draw.Src)
 	return ret
 }
 
@@ -134,54 +148,31 @@ func ChannelCompose(r, g, b, a ChannelImage) ChannelImage {
 	}
 }
 
+// ChannelRGBToYCbCr converts R, G and B channels to Y, Cb and Cr channels.
+func ChannelRGBToYCbCr(r, g, b ChannelImage) (y, cb, cr ChannelImage) {
+	y = NewChannelImageWidthHeight(r.Width, r.Height)
+	cb = NewChannelImageWidthHeight(r.Width, r.Height)
+	cr = NewChannelImageWidthHeight(r.Width, r.Height)
+	for i := range r.Buffer {
+		y.Buffer[i], cb.Buffer[i], cr.Buffer[i] = color.RGBToYCbCr(r.Buffer[i], g.Buffer[i], b.Buffer[i])
+	}
+	return y, cb, cr
+}
+
+// ChannelYCbCrToRGB converts Y, Cb and Cr channels to R, G and B channels.
+func ChannelYCbCrToRGB(y, cb, cr ChannelImage) (r, g, b ChannelImage) {
+	r = NewChannelImageWidthHeight(y.Width, y.Height)
+	g = NewChannelImageWidthHeight(y.Width, y.Height)
+	b = NewChannelImageWidthHeight(y.Width, y.Height)
+	for i := range y.Buffer {
+		r.Buffer[i], g.Buffer[i], b.Buffer[i] = color.YCbCrToRGB(y.Buffer[i], cb.Buffer[i], cr.Buffer[i])
+	}
+	return r, g, b
+}
+
 // Extrapolation calculates an extrapolation algorithm.
 func (c ChannelImage) Extrapolation(px int) ChannelImage {
-	width := c.Width
-	height := c.Height
-	toIndex := func(w, h int) int {
-		return w + h*width
-	}
-	imageEx := NewChannelImageWidthHeight(width+(2*px), height+(2*px))
-	for h := 0; h < height+(px*2); h++ {
-		for w := 0; w < width+(px*2); w++ {
-			index := w + h*(width+(px*2))
-			if w < px {
-				// Left outer area
-				if h < px {
-					// Left upper area
-					imageEx.Buffer[index] = c.Buffer[toIndex(0, 0)]
-				} else if px+height <= h {
-					// Left lower area
-					imageEx.Buffer[index] = c.Buffer[toIndex(0, height-1)]
-				} else {
-					// Left outer area
-					imageEx.Buffer[index] = c.Buffer[toIndex(0, h-px)]
-				}
-			} else if px+width <= w {
-				// Right outer area
-				if h < px {
-					// Right upper area
-					imageEx.Buffer[index] = c.Buffer[toIndex(width-1, 0)]
-				} else if px+height <= h {
-					// Right lower area
-					imageEx.Buffer[index] = c.Buffer[toIndex(width-1, height-1)]
-				} else {
-					// Right outer area
-					imageEx.Buffer[index] = c.Buffer[toIndex(width-1, h-px)]
-				}
-			} else if h < px {
-				// Upper outer area
-				imageEx.Buffer[index] = c.Buffer[toIndex(w-px, 0)]
-			} else if px+height <= h {
-				// Lower outer area
-				imageEx.Buffer[index] = c.Buffer[toIndex(w-px, height-1)]
-			} else {
-				// Inner area
-				imageEx.Buffer[index] = c.Buffer[toIndex(w-px, h-px)]
-			}
-		}
-	}
-	return imageEx
+	return c.remap(extrapolationIndices(c.Width, px), extrapolationIndices(c.Height, px))
 }
 
 // Resize returns a resized image.
@@ -189,25 +180,37 @@ func (c ChannelImage) Resize(scale float64) ChannelImage {
 	if scale == 1.0 {
 		return c
 	}
-	width := c.Width
-	height := c.Height
-	scaledWidth := int(math.Round(float64(width) * scale))
-	scaledHeight := int(math.Round(float64(height) * scale))
-	scaledImage := NewChannelImageWidthHeight(scaledWidth, scaledHeight)
-	for w := 0; w < scaledWidth; w++ {
-		for h := 0; h < scaledHeight; h++ {
-			scaledIndex := w + (h * scaledWidth)
-			wOriginal := int(math.Round(float64(w+1)/scale) - 1)
-			if wOriginal < 0 {
-				wOriginal = 0
-			}
-			hOriginal := int(math.Round(float64(h+1)/scale) - 1)
-			if hOriginal < 0 {
-				hOriginal = 0
-			}
-			indexOriginal := wOriginal + (hOriginal * width)
-			scaledImage.Buffer[scaledIndex] = c.Buffer[indexOriginal]
+	return c.remap(nearestIndices(c.Width, scale), nearestIndices(c.Height, scale))
+}
+
+// remap returns the image whose pixel at (x, y) is the one at (xs[x], ys[y]) of the image.
+func (c ChannelImage) remap(xs, ys []int) ChannelImage {
+	ret := NewChannelImageWidthHeight(len(xs), len(ys))
+	for y, sy := range ys {
+		row := ret.Buffer[y*ret.Width : (y+1)*ret.Width]
+		src := c.Buffer[sy*c.Width : (sy+1)*c.Width]
+		for x, sx := range xs {
+			row[x] = src[sx]
 		}
 	}
-	return scaledImage
+	return ret
+}
+
+// extrapolationIndices returns the indices of the n pixels extended by px on both sides,
+// i.e. the pixels outside are the ones of the edges.
+func extrapolationIndices(n, px int) []int {
+	ret := make([]int, n+2*px)
+	for i := range ret {
+		ret[i] = clamp(i-px, 0, n-1)
+	}
+	return ret
+}
+
+// nearestIndices returns the indices of the nearest neighbors of the n pixels scaled by the scale.
+func nearestIndices(n int, scale float64) []int {
+	ret := make([]int, int(math.Round(float64(n)*scale)))
+	for i := range ret {
+		ret[i] = clamp(int(math.Round(float64(i+1)/scale)-1), 0, n-1)
+	}
+	return ret
 }
@@ -0,0 +1,308 @@
+package engine
+
+import (
+	"fmt"
+	"image"
+	"math"
+)
+
+// ChannelImage16 represents a discrete image of 16 bits per sample, see ChannelImage.
+// The models are applied to the channels of 16 bits, so that the passes do not lose the precision.
+type ChannelImage16 struct {
+	Width  int
+	Height int
+	Buffer []uint16
+}
+
+func newChannelImage16(width, height int) ChannelImage16 {
+	return ChannelImage16{
+		Width:  width,
+		Height: height,
+		Buffer: make([]uint16, width*height),
+	}
+}
+
+// NewChannelImage16 returns a channel image of 16 bits corresponding to the specified image, and whether it is opaque.
+// The pixels are converted to the alpha-premultiplied RGBA of 16 bits as image.RGBA64, see NewChannelImage.
+func NewChannelImage16(img image.Image) (ChannelImage16, bool, error) {
+	r := img.Bounds()
+	if r.Empty() {
+		return ChannelImage16{}, false, fmt.Errorf("empty image: %v", r)
+	}
+	ret := ChannelImage16{
+		Width:  r.Dx(),
+		Height: r.Dy(),
+		Buffer: make([]uint16, r.Dx()*r.Dy()*4),
+	}
+	switch t := img.(type) {
+	case *image.RGBA64:
+		convertRGBA64To16(ret.Buffer, t, r)
+	case *image.NRGBA64:
+		convertNRGBA64To16(ret.Buffer, t, r)
+	case *image.Gray16:
+		convertGray16To16(ret.Buffer, t, r)
+	case *image.RGBA, *image.NRGBA, *image.Gray, *image.CMYK, *image.YCbCr, *image.NYCbCrA, *image.Paletted:
+		// the images of 8 bits
+		ci, opaque, err := NewChannelImage(img)
+		if err != nil {
+			return ChannelImage16{}, false, err
+		}
+		return ci.channelImage16(), opaque, nil
+	default:
+		convertImageTo16(ret.Buffer, img, r)
+	}
+	opaque := true
+	for i := 3; i < len(ret.Buffer); i += 4 {
+		if ret.Buffer[i] != 0xffff {
+			opaque = false
+			break
+		}
+	}
+	return ret, opaque, nil
+}
+
+func convertRGBA64To16(dst []uint16, src *image.RGBA64, r image.Rectangle) {
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		i := src.PixOffset(r.Min.X, y)
+		row := src.Pix[i : i+r.Dx()*8]
+		for j := range dst[:r.Dx()*4] {
+			dst[j] = uint16(row[j*2])<<8 | uint16(row[j*2+1])
+		}
+		dst = dst[r.Dx()*4:]
+	}
+}
+
+func convertNRGBA64To16(dst []uint16, src *image.NRGBA64, r image.Rectangle) {
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		i := src.PixOffset(r.Min.X, y)
+		row := src.Pix[i : i+r.Dx()*8]
+		for x := 0; x < len(row); x += 8 {
+			s := row[x : x+8 : x+8]
+			a := uint32(s[6])<<8 | uint32(s[7])
+			dst[0] = uint16((uint32(s[0])<<8 | uint32(s[1])) * a / 0xffff)
+			dst[1] = uint16((uint32(s[2])<<8 | uint32(s[3])) * a / 0xffff)
+			dst[2] = uint16((uint32(s[4])<<8 | uint32(s[5])) * a / 0xffff)
+			dst[3] = uint16(a)
+			dst = dst[4:]
+		}
+	}
+}
+
+func convertGray16To16(dst []uint16, src *image.Gray16, r image.Rectangle) {
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		i := src.PixOffset(r.Min.X, y)
+		row := src.Pix[i : i+r.Dx()*2]
+		for x := 0; x < len(row); x += 2 {
+			v := uint16(row[x])<<8 | uint16(row[x+1])
+			dst[0], dst[1], dst[2], dst[3] = v, v, v, 0xffff
+			dst = dst[4:]
+		}
+	}
+}
+
+// convertImageTo16 converts any image with its color model.
+func convertImageTo16(dst []uint16, src image.Image, r image.Rectangle) {
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			R, G, B, A := src.At(x, y).RGBA()
+			dst[0], dst[1], dst[2], dst[3] = uint16(R), uint16(G), uint16(B), uint16(A)
+			dst = dst[4:]
+		}
+	}
+}
+
+// channelImage16 returns the channel image of 16 bits whose values are the same as the ones of 8 bits.
+func (c ChannelImage) channelImage16() ChannelImage16 {
+	ret := ChannelImage16{
+		Width:  c.Width,
+		Height: c.Height,
+		Buffer: make([]uint16, len(c.Buffer)),
+	}
+	for i, v := range c.Buffer {
+		ret.Buffer[i] = uint16(v) * 0x101
+	}
+	return ret
+}
+
+// ChannelImage returns the channel image of 8 bits, whose values are rounded.
+func (c ChannelImage16) ChannelImage() ChannelImage {
+	ret := ChannelImage{
+		Width:  c.Width,
+		Height: c.Height,
+		Buffer: make([]uint8, len(c.Buffer)),
+	}
+	for i, v := range c.Buffer {
+		ret.Buffer[i] = uint8((uint32(v)*0xff + 0x7fff) / 0xffff)
+	}
+	return ret
+}
+
+// ImageNRGBA64 converts the channel image to an image.NRGBA64, i.e. the colors are divided by the alpha.
+// The colors greater than the alpha, which the models may output, are clamped to 0xffff.
+func (c ChannelImage16) ImageNRGBA64() *image.NRGBA64 {
+	ret := image.NewNRGBA64(image.Rect(0, 0, c.Width, c.Height))
+	for i := 0; i < len(c.Buffer); i += 4 {
+		s := c.Buffer[i : i+4 : i+4]
+		a := uint32(s[3])
+		for k, v := range s {
+			if k < 3 && a != 0 && a != 0xffff {
+				u := (uint32(v)*0xffff + a/2) / a
+				if u > 0xffff {
+					u = 0xffff
+				}
+				v = uint16(u)
+			}
+			ret.Pix[i*2+k*2], ret.Pix[i*2+k*2+1] = uint8(v>>8), uint8(v)
+		}
+	}
+	return ret
+}
+
+func channelDecompose16(img ChannelImage16) (r, g, b, a ChannelImage16) {
+	r = newChannelImage16(img.Width, img.Height)
+	g = newChannelImage16(img.Width, img.Height)
+	b = newChannelImage16(img.Width, img.Height)
+	a = newChannelImage16(img.Width, img.Height)
+	for i := range r.Buffer {
+		r.Buffer[i] = img.Buffer[i*4]
+		g.Buffer[i] = img.Buffer[i*4+1]
+		b.Buffer[i] = img.Buffer[i*4+2]
+		a.Buffer[i] = img.Buffer[i*4+3]
+	}
+	return r, g, b, a
+}
+
+func channelCompose16(r, g, b, a ChannelImage16) ChannelImage16 {
+	ret := ChannelImage16{
+		Width:  r.Width,
+		Height: r.Height,
+		Buffer: make([]uint16, r.Width*r.Height*4),
+	}
+	for i := range r.Buffer {
+		ret.Buffer[i*4] = r.Buffer[i]
+		ret.Buffer[i*4+1] = g.Buffer[i]
+		ret.Buffer[i*4+2] = b.Buffer[i]
+		ret.Buffer[i*4+3] = a.Buffer[i]
+	}
+	return ret
+}
+
+// channelRGBToYCbCr16 converts R, G and B channels to Y, Cb and Cr channels of the JFIF.
+func channelRGBToYCbCr16(r, g, b ChannelImage16) (y, cb, cr ChannelImage16) {
+	y = newChannelImage16(r.Width, r.Height)
+	cb = newChannelImage16(r.Width, r.Height)
+	cr = newChannelImage16(r.Width, r.Height)
+	for i := range r.Buffer {
+		R, G, B := float64(r.Buffer[i]), float64(g.Buffer[i]), float64(b.Buffer[i])
+		y.Buffer[i] = clampUint16(0.299*R + 0.587*G + 0.114*B)
+		cb.Buffer[i] = clampUint16(-0.168736*R - 0.331264*G + 0.5*B + 0x8000)
+		cr.Buffer[i] = clampUint16(0.5*R - 0.418688*G - 0.081312*B + 0x8000)
+	}
+	return y, cb, cr
+}
+
+// channelYCbCrToRGB16 converts Y, Cb and Cr channels of the JFIF to R, G and B channels.
+func channelYCbCrToRGB16(y, cb, cr ChannelImage16) (r, g, b ChannelImage16) {
+	r = newChannelImage16(y.Width, y.Height)
+	g = newChannelImage16(y.Width, y.Height)
+	b = newChannelImage16(y.Width, y.Height)
+	for i := range y.Buffer {
+		Y, Cb, Cr := float64(y.Buffer[i]), float64(cb.Buffer[i])-0x8000, float64(cr.Buffer[i])-0x8000
+		r.Buffer[i] = clampUint16(Y + 1.402*Cr)
+		g.Buffer[i] = clampUint16(Y - 0.344136*Cb - 0.714136*Cr)
+		b.Buffer[i] = clampUint16(Y + 1.772*Cb)
+	}
+	return r, g, b
+}
+
+func clampUint16(v float64) uint16 {
+	return uint16(math.Max(0, math.Min(0xffff, math.Round(v))))
+}
+
+func (c ChannelImage16) extrapolation(px int) ChannelImage16 {
+	return c.remap(extrapolationIndices(c.Width, px), extrapolationIndices(c.Height, px))
+}
+
+func (c ChannelImage16) resize(scale float64) ChannelImage16 {
+	if scale == 1.0 {
+		return c
+	}
+	return c.remap(nearestIndices(c.Width, scale), nearestIndices(c.Height, scale))
+}
+
+// remap returns the image whose pixel at (x, y) is the one at (xs[x], ys[y]) of the image.
+func (c ChannelImage16) remap(xs, ys []int) ChannelImage16 {
+	ret := newChannelImage16(len(xs), len(ys))
+	for y, sy := range ys {
+		row := ret.Buffer[y*ret.Width : (y+1)*ret.Width]
+		src := c.Buffer[sy*c.Width : (sy+1)*c.Width]
+		for x, sx := range xs {
+			row[x] = src[sx]
+		}
+	}
+	return ret
+}
+
+// normalizedPlane returns the image plane of the values in [0, 1].
+func (c ChannelImage16) normalizedPlane() ImagePlane {
+	p := NewImagePlaneWidthHeight(c.Width, c.Height)
+	for i, v := range c.Buffer {
+		p.Buffer[i] = float32(v) / 0xffff
+	}
+	return p
+}
+
+// setDenormalized sets the denormalized values of the image plane at (x, y) of the channel image.
+func (c *ChannelImage16) setDenormalized(x, y int, p ImagePlane) {
+	for h := 0; h < p.Height; h++ {
+		row := c.Buffer[(y+h)*c.Width+x : (y+h)*c.Width+x+p.Width]
+		for w, v := range p.Buffer[h*p.Width : (h+1)*p.Width] {
+			row[w] = denormalize16(v)
+		}
+	}
+}
+
+func denormalize16(v float32) uint16 {
+	i := int(math.Round(float64(v) * 0xffff))
+	if i < 0 {
+		return 0
+	} else if i > 0xffff {
+		return 0xffff
+	}
+	return uint16(i)
+}
+
+func (c ChannelImage16) resizeBicubic(width, height int) ChannelImage16 {
+	return c.resample(width, height, catmullRom, 2)
+}
+
+func (c ChannelImage16) resizeLanczos(width, height int) ChannelImage16 {
+	return c.resample(width, height, lanczos3, 3)
+}
+
+func (c ChannelImage16) resample(width, height int, kernel func(float64) float64, support float64) ChannelImage16 {
+	if width == c.Width && height == c.Height {
+		return c
+	}
+	p := c.normalizedPlane().resample(width, height, kernel, support)
+	ret := newChannelImage16(width, height)
+	ret.setDenormalized(0, 0, p)
+	return ret
+}
+
+// crop returns the rectangle of the channel image.
+func (c ChannelImage16) crop(r image.Rectangle) ChannelImage16 {
+	if r == image.Rect(0, 0, c.Width, c.Height) {
+		return c
+	}
+	n := len(c.Buffer) / (c.Width * c.Height) // samples per pixel
+	ret := ChannelImage16{
+		Width:  r.Dx(),
+		Height: r.Dy(),
+		Buffer: make([]uint16, 0, r.Dx()*r.Dy()*n),
+	}
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		ret.Buffer = append(ret.Buffer, c.Buffer[(y*c.Width+r.Min.X)*n:(y*c.Width+r.Max.X)*n]...)
+	}
+	return ret
+}
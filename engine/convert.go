@@ -0,0 +1,167 @@
+package engine
+
+import (
+	"image"
+	"image/color"
+)
+
+// The converters below write the pixels of the rectangle of the image to the buffer of the channel image,
+// 4 bytes per pixel in the alpha-premultiplied RGBA as image.RGBA.
+// The 16-bit values are truncated to the high bytes, and the straight alpha values, i.e. of image.NRGBA,
+// image.NRGBA64 and image.NYCbCrA, are premultiplied in 16 bits as their RGBA methods do.
+
+// premultiply returns the 8-bit color premultiplied by the alpha, both of them are 16-bit.
+func premultiply(c, a uint32) uint8 {
+	return uint8(c * a / 0xffff >> 8)
+}
+
+func convertRGBA(dst []uint8, src *image.RGBA, r image.Rectangle) {
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		i := src.PixOffset(r.Min.X, y)
+		dst = dst[copy(dst, src.Pix[i:i+r.Dx()*4]):]
+	}
+}
+
+func convertNRGBA(dst []uint8, src *image.NRGBA, r image.Rectangle) {
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		i := src.PixOffset(r.Min.X, y)
+		row := src.Pix[i : i+r.Dx()*4]
+		for x := 0; x < len(row); x += 4 {
+			s := row[x : x+4 : x+4]
+			a := uint32(s[3]) * 0x101
+			dst[0] = premultiply(uint32(s[0])*0x101, a)
+			dst[1] = premultiply(uint32(s[1])*0x101, a)
+			dst[2] = premultiply(uint32(s[2])*0x101, a)
+			dst[3] = s[3]
+			dst = dst[4:]
+		}
+	}
+}
+
+func convertRGBA64(dst []uint8, src *image.RGBA64, r image.Rectangle) {
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		i := src.PixOffset(r.Min.X, y)
+		row := src.Pix[i : i+r.Dx()*8]
+		for j := range dst[:r.Dx()*4] {
+			dst[j] = row[j*2] // the high byte of the big endian
+		}
+		dst = dst[r.Dx()*4:]
+	}
+}
+
+func convertNRGBA64(dst []uint8, src *image.NRGBA64, r image.Rectangle) {
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		i := src.PixOffset(r.Min.X, y)
+		row := src.Pix[i : i+r.Dx()*8]
+		for x := 0; x < len(row); x += 8 {
+			s := row[x : x+8 : x+8]
+			a := uint32(s[6])<<8 | uint32(s[7])
+			dst[0] = premultiply(uint32(s[0])<<8|uint32(s[1]), a)
+			dst[1] = premultiply(uint32(s[2])<<8|uint32(s[3]), a)
+			dst[2] = premultiply(uint32(s[4])<<8|uint32(s[5]), a)
+			dst[3] = s[6]
+			dst = dst[4:]
+		}
+	}
+}
+
+func convertGray(dst []uint8, src *image.Gray, r image.Rectangle) {
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		i := src.PixOffset(r.Min.X, y)
+		for _, v := range src.Pix[i : i+r.Dx()] {
+			dst[0], dst[1], dst[2], dst[3] = v, v, v, 0xff
+			dst = dst[4:]
+		}
+	}
+}
+
+func convertGray16(dst []uint8, src *image.Gray16, r image.Rectangle) {
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		i := src.PixOffset(r.Min.X, y)
+		row := src.Pix[i : i+r.Dx()*2]
+		for x := 0; x < r.Dx(); x++ {
+			v := row[x*2]
+			dst[0], dst[1], dst[2], dst[3] = v, v, v, 0xff
+			dst = dst[4:]
+		}
+	}
+}
+
+func convertCMYK(dst []uint8, src *image.CMYK, r image.Rectangle) {
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		i := src.PixOffset(r.Min.X, y)
+		row := src.Pix[i : i+r.Dx()*4]
+		for x := 0; x < len(row); x += 4 {
+			s := row[x : x+4 : x+4]
+			dst[0], dst[1], dst[2] = color.CMYKToRGB(s[0], s[1], s[2], s[3])
+			dst[3] = 0xff
+			dst = dst[4:]
+		}
+	}
+}
+
+func convertYCbCr(dst []uint8, src *image.YCbCr, r image.Rectangle) {
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			yi, ci := src.YOffset(x, y), src.COffset(x, y)
+			dst[0], dst[1], dst[2] = color.YCbCrToRGB(src.Y[yi], src.Cb[ci], src.Cr[ci])
+			dst[3] = 0xff
+			dst = dst[4:]
+		}
+	}
+}
+
+func convertNYCbCrA(dst []uint8, src *image.NYCbCrA, r image.Rectangle) {
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			yi, ci := src.YOffset(x, y), src.COffset(x, y)
+			c := color.NYCbCrA{
+				YCbCr: color.YCbCr{Y: src.Y[yi], Cb: src.Cb[ci], Cr: src.Cr[ci]},
+				A:     src.A[src.AOffset(x, y)],
+			}
+			R, G, B, _ := c.RGBA() // premultiplied in 16 bits
+			dst[0], dst[1], dst[2], dst[3] = uint8(R>>8), uint8(G>>8), uint8(B>>8), c.A
+			dst = dst[4:]
+		}
+	}
+}
+
+func convertPaletted(dst []uint8, src *image.Paletted, r image.Rectangle) {
+	// the colors of the palette are converted once, and the indices out of the palette are transparent black
+	var palette [256][4]uint8
+	for i, c := range src.Palette {
+		if i == len(palette) {
+			break
+		}
+		R, G, B, A := c.RGBA()
+		palette[i] = [4]uint8{uint8(R >> 8), uint8(G >> 8), uint8(B >> 8), uint8(A >> 8)}
+	}
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		i := src.PixOffset(r.Min.X, y)
+		for _, v := range src.Pix[i : i+r.Dx()] {
+			copy(dst, palette[v][:])
+			dst = dst[4:]
+		}
+	}
+}
+
+// convertImage converts any image with its color model, which is much slower than the converters above.
+func convertImage(dst []uint8, src image.Image, r image.Rectangle) {
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			R, G, B, A := src.At(x, y).RGBA()
+			dst[0], dst[1], dst[2], dst[3] = uint8(R>>8), uint8(G>>8), uint8(B>>8), uint8(A>>8)
+			dst = dst[4:]
+		}
+	}
+}
+
+// isOpaque reports whether all the alpha values of the pixels of 4 bytes are 0xff.
+func isOpaque(buf []uint8) bool {
+	for i := 3; i < len(buf); i += 4 {
+		if buf[i] != 0xff {
+			return false
+		}
+	}
+	return true
+}
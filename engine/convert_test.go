@@ -0,0 +1,179 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// randomImages returns the images of all the types of the standard library with random pixels.
+func randomImages(seed int64, r image.Rectangle) []image.Image {
+	rnd := rand.New(rand.NewSource(seed))
+	random := func(pix []uint8) {
+		for i := range pix {
+			pix[i] = uint8(rnd.Intn(256))
+		}
+	}
+	rgba := image.NewRGBA(r)
+	for i := 0; i < len(rgba.Pix); i += 4 { // premultiplied
+		a := uint8(rnd.Intn(256))
+		rgba.Pix[i], rgba.Pix[i+1], rgba.Pix[i+2], rgba.Pix[i+3] = uint8(rnd.Intn(int(a)+1)), uint8(rnd.Intn(int(a)+1)), uint8(rnd.Intn(int(a)+1)), a
+	}
+	rgba64 := image.NewRGBA64(r)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			a := uint16(rnd.Intn(0x10000))
+			rgba64.SetRGBA64(x, y, color.RGBA64{R: a / 2, G: a / 3, B: a, A: a})
+		}
+	}
+	nrgba := image.NewNRGBA(r)
+	random(nrgba.Pix)
+	nrgba64 := image.NewNRGBA64(r)
+	random(nrgba64.Pix)
+	gray := image.NewGray(r)
+	random(gray.Pix)
+	gray16 := image.NewGray16(r)
+	random(gray16.Pix)
+	cmyk := image.NewCMYK(r)
+	random(cmyk.Pix)
+	ycbcr := image.NewYCbCr(r, image.YCbCrSubsampleRatio420)
+	random(ycbcr.Y)
+	random(ycbcr.Cb)
+	random(ycbcr.Cr)
+	nycbcra := image.NewNYCbCrA(r, image.YCbCrSubsampleRatio422)
+	random(nycbcra.Y)
+	random(nycbcra.Cb)
+	random(nycbcra.Cr)
+	random(nycbcra.A)
+	paletted := image.NewPaletted(r, palette.Plan9)
+	random(paletted.Pix)
+	alpha := image.NewAlpha(r)
+	random(alpha.Pix)
+	return []image.Image{rgba, rgba64, nrgba, nrgba64, gray, gray16, cmyk, ycbcr, nycbcra, paletted, alpha}
+}
+
+func TestNewChannelImage(t *testing.T) {
+	r := image.Rect(0, 0, 13, 7)
+	for _, img := range randomImages(1, r) {
+		t.Run(fmt.Sprintf("%T", img), func(t *testing.T) {
+			got, opaque, err := NewChannelImage(img)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got.Width != r.Dx() || got.Height != r.Dy() {
+				t.Fatalf("want %dx%d, got %dx%d", r.Dx(), r.Dy(), got.Width, got.Height)
+			}
+			want := make([]uint8, r.Dx()*r.Dy()*4)
+			convertImage(want, img, r)
+			for i := range want {
+				if want[i] != got.Buffer[i] {
+					t.Fatalf("index %d: want %d, got %d", i, want[i], got.Buffer[i])
+				}
+			}
+			if o, ok := img.(interface{ Opaque() bool }); ok && o.Opaque() != opaque {
+				t.Errorf("want opaque %v, got %v", o.Opaque(), opaque)
+			}
+		})
+	}
+}
+
+func TestNewChannelImage_Premultiplied(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 1, 1))
+	img.Pix = []uint8{200, 100, 50, 128}
+	got, opaque, err := NewChannelImage(img)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []uint8{100, 50, 25, 128}; !reflect.DeepEqual(want, got.Buffer) {
+		t.Errorf("want %v, got %v", want, got.Buffer)
+	}
+	if opaque {
+		t.Errorf("want not opaque")
+	}
+}
+
+func TestNewChannelImage16(t *testing.T) {
+	r := image.Rect(0, 0, 13, 7)
+	for _, img := range randomImages(1, r) {
+		t.Run(fmt.Sprintf("%T", img), func(t *testing.T) {
+			got, opaque, err := NewChannelImage16(img)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			want := make([]uint16, r.Dx()*r.Dy()*4)
+			convertImageTo16(want, img, r)
+			tolerance := 0x101 // the images of 8 bits are converted in 8 bits
+			switch img.(type) {
+			case *image.RGBA64, *image.NRGBA64, *image.Gray16:
+				tolerance = 0
+			}
+			for i := range want {
+				if d := int(want[i]) - int(got.Buffer[i]); d < -tolerance || d > tolerance {
+					t.Fatalf("index %d: want %d, got %d", i, want[i], got.Buffer[i])
+				}
+			}
+			if o, ok := img.(interface{ Opaque() bool }); ok && o.Opaque() != opaque {
+				t.Errorf("want opaque %v, got %v", o.Opaque(), opaque)
+			}
+		})
+	}
+}
+
+func TestChannelImage_ImageRGBA(t *testing.T) {
+	c := ChannelImage{Width: 2, Height: 1, Buffer: []uint8{200, 10, 0, 128, 50, 60, 70, 255}}
+	rgba := c.ImageRGBA()
+	if want, got := []uint8{128, 10, 0, 128, 50, 60, 70, 255}, rgba.Pix; !reflect.DeepEqual(want, got) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+	// the models may output the colors greater than the alpha of a semi-transparent image
+	img := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = 255, uint8(i), 255, 64
+	}
+	w2x, err := NewWaifu2xModelSet(&ModelSet{Scale2xModel: passThroughModel(1, []int{3, 8, 3})})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ci, err := w2x.ScaleUp(context.TODO(), img, 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	rgba = ci.ImageRGBA()
+	for i := 0; i < len(rgba.Pix); i += 4 {
+		if p := rgba.Pix[i : i+4]; p[0] > p[3] || p[1] > p[3] || p[2] > p[3] {
+			t.Fatalf("index %d: the colors %v are greater than the alpha", i/4, p)
+		}
+	}
+}
+
+func TestChannelImage16_ImageNRGBA64(t *testing.T) {
+	img := image.NewNRGBA64(image.Rect(0, 0, 3, 1))
+	img.SetNRGBA64(0, 0, color.NRGBA64{R: 0x1234, G: 0x5678, B: 0x9abc, A: 0xffff})
+	img.SetNRGBA64(1, 0, color.NRGBA64{R: 0xffff, G: 0x8000, B: 0, A: 0x8000})
+	ci, _, err := NewChannelImage16(img)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got := ci.ImageNRGBA64()
+	for x := 0; x < 3; x++ {
+		want, got := img.NRGBA64At(x, 0), got.NRGBA64At(x, 0)
+		for _, d := range []int{int(want.R) - int(got.R), int(want.G) - int(got.G), int(want.B) - int(got.B), int(want.A) - int(got.A)} {
+			if d < -1 || d > 1 {
+				t.Errorf("x=%d: want %v, got %v", x, want, got)
+			}
+		}
+	}
+	if want, got := []uint8{0x12, 0x56, 0x9a, 0xff}, ci.ChannelImage().Buffer[:4]; !reflect.DeepEqual(want, got) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+	// the color greater than the alpha is clamped
+	c := ChannelImage16{Width: 1, Height: 1, Buffer: []uint16{0x6000, 0, 0, 0x4000}}
+	if want, got := (color.NRGBA64{R: 0xffff, A: 0x4000}), c.ImageNRGBA64().NRGBA64At(0, 0); want != got {
+		t.Errorf("want %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,216 @@
+package engine
+
+import (
+	"fmt"
+	"math"
+	"sync"
+)
+
+// Precision is the precision of the inference.
+type Precision int
+
+const (
+	// Float32 computes and stores everything in float32, the default.
+	Float32 Precision = iota + 1
+	// Float16Weights stores the weights of the models in half precision.
+	Float16Weights
+	// Float16 stores the weights and the input planes of the models in half precision,
+	// the layers of a block are computed in float32.
+	Float16
+)
+
+// String returns the name of the precision.
+func (p Precision) String() string {
+	switch p {
+	case Float32:
+		return "float32"
+	case Float16Weights:
+		return "float16-weights"
+	case Float16:
+		return "float16"
+	}
+	return fmt.Sprintf("Precision(%d)", int(p))
+}
+
+// InferencePrecision sets the option that specifies the precision to store the weights and the feature maps.
+// The values in half precision are converted to float32 on the fly, so they halve the memory
+// at the cost of the accuracy.
+func InferencePrecision(p Precision) Option {
+	return func(w *Waifu2x) error {
+		if p < Float32 || p > Float16 {
+			return fmt.Errorf("unknown precision: %v", p)
+		}
+		w.precision = p
+		return nil
+	}
+}
+
+// float32ToHalf converts the float32 value to the IEEE 754 half precision value, rounding to nearest even.
+func float32ToHalf(f float32) uint16 {
+	b := math.Float32bits(f)
+	sign := uint16(b>>16) & 0x8000
+	exp := int((b>>23)&0xff) - 127 + 15
+	mant := b & 0x7fffff
+	switch {
+	case (b>>23)&0xff == 0xff: // Inf or NaN
+		if mant != 0 {
+			return sign | 0x7e00
+		}
+		return sign | 0x7c00
+	case exp >= 0x1f: // overflow
+		return sign | 0x7c00
+	case exp <= 0: // subnormal or zero
+		if exp < -10 {
+			return sign
+		}
+		mant |= 0x800000
+		shift := uint(14 - exp)
+		h := mant >> shift
+		rem := mant & (1<<shift - 1)
+		mid := uint32(1) << (shift - 1)
+		if rem > mid || (rem == mid && h&1 == 1) {
+			h++
+		}
+		return sign | uint16(h)
+	}
+	h := uint32(exp)<<10 | mant>>13
+	rem := mant & 0x1fff
+	if rem > 0x1000 || (rem == 0x1000 && h&1 == 1) {
+		h++ // a carry to the exponent is correct, up to Inf
+	}
+	return sign | uint16(h)
+}
+
+// halfToFloat32 converts the IEEE 754 half precision value to the float32 value.
+func halfToFloat32(h uint16) float32 {
+	sign := uint32(h&0x8000) << 16
+	exp := uint32(h>>10) & 0x1f
+	mant := uint32(h & 0x3ff)
+	switch exp {
+	case 0x1f: // Inf or NaN
+		return math.Float32frombits(sign | 0x7f800000 | mant<<13)
+	case 0:
+		if mant == 0 {
+			return math.Float32frombits(sign)
+		}
+		// normalize the subnormal value
+		e := uint32(127 - 15 + 1)
+		for mant&0x400 == 0 {
+			mant <<= 1
+			e--
+		}
+		return math.Float32frombits(sign | e<<23 | (mant&0x3ff)<<13)
+	}
+	return math.Float32frombits(sign | (exp+127-15)<<23 | mant<<13)
+}
+
+var (
+	halfTableOnce sync.Once
+	halfTable     *[1 << 16]float32
+)
+
+// halfToFloat32Table returns the table which converts half precision values to float32 values.
+func halfToFloat32Table() *[1 << 16]float32 {
+	halfTableOnce.Do(func() {
+		var t [1 << 16]float32
+		for i := range t {
+			t[i] = halfToFloat32(uint16(i))
+		}
+		halfTable = &t
+	})
+	return halfTable
+}
+
+// halfPlane is an image plane which stores the values in half precision.
+type halfPlane struct {
+	Width  int
+	Height int
+	Buffer []uint16
+}
+
+func newHalfPlanes(planes []ImagePlane) []halfPlane {
+	ret := make([]halfPlane, len(planes))
+	for i, p := range planes {
+		ret[i] = halfPlane{Width: p.Width, Height: p.Height, Buffer: make([]uint16, len(p.Buffer))}
+		for j, v := range p.Buffer {
+			ret[i].Buffer[j] = float32ToHalf(v)
+		}
+	}
+	return ret
+}
+
+// halfWeights returns the copy of the model whose weights are stored in half precision instead of float32.
+// The weights are converted once for the loaded model, and shared by its copies.
+func (m Model) halfWeights() Model {
+	if m == nil {
+		return nil
+	}
+	ret := make(Model, len(m))
+	for l, p := range m {
+		if p.WeightVec != nil {
+			p.weightHalf = p.prepare(preparedHalf, func() interface{} {
+				half := make([]uint16, len(p.WeightVec))
+				for i, v := range p.WeightVec {
+					half[i] = float32ToHalf(v)
+				}
+				return half
+			}).([]uint16)
+		}
+		p.WeightVec = nil
+		p.Weight = nil
+		ret[l] = p
+	}
+	return ret
+}
+
+// weights returns the weight vector of the layer in float32.
+// The weights in half precision are decoded to the last temporary buffer of the scratch.
+func (p Param) weights(s *scratch) []float32 {
+	if p.weightHalf == nil {
+		return p.WeightVec
+	}
+	t := halfToFloat32Table()
+	ret := s.temp(3, len(p.weightHalf))
+	for i, v := range p.weightHalf {
+		ret[i] = t[v]
+	}
+	return ret
+}
+
+// convolutionHalf is the 3x3 convolution with the weights in half precision.
+func convolutionHalf(inputPlanes []ImagePlane, W []uint16, nOutputPlane int, bias []float32, s *scratch) []ImagePlane {
+	if len(inputPlanes) == 0 {
+		return nil
+	}
+	t := halfToFloat32Table()
+	width := inputPlanes[0].Width
+	height := inputPlanes[0].Height
+	outputPlanes := s.outputPlanes(nOutputPlane, width-2, height-2)
+	sumValues := s.temp(0, nOutputPlane)
+	for y := 1; y < height-1; y++ {
+		for x := 1; x < width-1; x++ {
+			copy(sumValues, bias)
+			const square = 9
+			wi := 0
+			for i := range inputPlanes {
+				a0, a1, a2, b0, b1, b2, c0, c1, c2 := inputPlanes[i].SegmentAt(x, y)
+				for o := 0; o < nOutputPlane; o++ {
+					ws := W[wi : wi+square] // 3x3 square
+					sumValues[o] = sumValues[o] +
+						t[ws[0]]*a0 + t[ws[1]]*a1 + t[ws[2]]*a2 +
+						t[ws[3]]*b0 + t[ws[4]]*b1 + t[ws[5]]*b2 +
+						t[ws[6]]*c0 + t[ws[7]]*c1 + t[ws[8]]*c2
+					wi += square
+				}
+			}
+			for o := 0; o < nOutputPlane; o++ {
+				v := sumValues[o]
+				if v < 0 {
+					v *= 0.1
+				}
+				outputPlanes[o].SetAt(x-1, y-1, v)
+			}
+		}
+	}
+	return outputPlanes
+}
@@ -0,0 +1,87 @@
+package engine
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+func Test_float32ToHalf(t *testing.T) {
+	testdata := []struct {
+		in   float32
+		want uint16
+	}{
+		{in: 0, want: 0x0000},
+		{in: float32(math.Copysign(0, -1)), want: 0x8000},
+		{in: 1, want: 0x3c00},
+		{in: -2, want: 0xc000},
+		{in: 0.1, want: 0x2e66},
+		{in: 65504, want: 0x7bff},
+		{in: 65520, want: 0x7c00},        // rounded up to Inf
+		{in: 1e-7, want: 0x0002},         // subnormal
+		{in: 1e-9, want: 0x0000},         // underflow
+		{in: 1 + 1.0/2048, want: 0x3c00}, // tie to even
+		{in: 1 + 3.0/2048, want: 0x3c02}, // tie to even
+		{in: float32(math.Inf(-1)), want: 0xfc00},
+	}
+	for _, v := range testdata {
+		if got := float32ToHalf(v.in); got != v.want {
+			t.Errorf("float32ToHalf(%v): want %#04x, got %#04x", v.in, v.want, got)
+		}
+	}
+	if got := float32ToHalf(float32(math.NaN())); got&0x7c00 != 0x7c00 || got&0x3ff == 0 {
+		t.Errorf("want NaN, got %#04x", got)
+	}
+}
+
+func Test_halfToFloat32(t *testing.T) {
+	// every finite half value must survive the round trip
+	for i := 0; i < 1<<16; i++ {
+		h := uint16(i)
+		if h&0x7c00 == 0x7c00 {
+			continue // Inf or NaN
+		}
+		if got := float32ToHalf(halfToFloat32(h)); got != h {
+			t.Errorf("round trip of %#04x: got %#04x", h, got)
+		}
+	}
+}
+
+func TestInferencePrecision_SharedModel(t *testing.T) {
+	m, err := NewAssetModelSet(Anime, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := NewWaifu2xModelSet(m, InferencePrecision(Float16)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if m.Scale2xModel[0].WeightVec == nil || m.Scale2xModel[0].weightHalf != nil {
+		t.Errorf("the model set must not be modified")
+	}
+}
+
+func TestNewWaifu2x_HalfWeights(t *testing.T) {
+	a, err := NewWaifu2x(AnimeY, 2, InferencePrecision(Float16))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	b, err := NewWaifu2x(AnimeY, 2, InferencePrecision(Float16Weights))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for l := range a.scaleModel {
+		pa, pb := a.scaleModel[l], b.scaleModel[l]
+		if pa.WeightVec != nil || len(pa.weightHalf) == 0 || &pa.weightHalf[0] != &pb.weightHalf[0] {
+			t.Errorf("layer %d: expected the weights shared only in half precision", l)
+		}
+	}
+	cached, err := DefaultModelCache.Load("assets:"+animeYModelDir+"/"+ScaleModelName+".bin:float16", func() (Model, error) {
+		return nil, fmt.Errorf("the model is not cached")
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cached[0].WeightVec != nil || &cached[0].weightHalf[0] != &a.scaleModel[0].weightHalf[0] {
+		t.Errorf("expected the model cached only in half precision")
+	}
+}
@@ -0,0 +1,76 @@
+package engine
+
+// fmaWeights returns the copy of the model whose 3x3 convolution layers have the weights for convolutionFMA
+// if the CPU supports AVX2 and FMA, and the layers have no weights prepared for the other kernels.
+// The weights are transposed once for the loaded model, and shared by its copies.
+func (m Model) fmaWeights() Model {
+	if m == nil || !useAVX2FMA {
+		return m
+	}
+	ret := make(Model, len(m))
+	for l, p := range m {
+		if p.Type() == Convolution && p.KW == 3 && p.WeightVec != nil && p.weightInt8 == nil && p.weightHalf == nil {
+			p.weightFMA = p.prepare(preparedFMA, func() interface{} {
+				return transposeSquares(p.WeightVec, p.NInputPlane, p.NOutputPlane, 9)
+			}).([]float32)
+		}
+		ret[l] = p
+	}
+	return ret
+}
+
+// transposeSquares converts the weights [nInputPlane][nOutputPlane][square] to [nInputPlane][square][nOutputPlane].
+func transposeSquares(w []float32, nInputPlane, nOutputPlane, square int) []float32 {
+	ret := make([]float32, len(w))
+	for i := 0; i < nInputPlane; i++ {
+		for o := 0; o < nOutputPlane; o++ {
+			for k := 0; k < square; k++ {
+				ret[(i*square+k)*nOutputPlane+o] = w[(i*nOutputPlane+o)*square+k]
+			}
+		}
+	}
+	return ret
+}
+
+// convolutionFMA is the 3x3 convolution which multiplies and accumulates the segments of all the input planes
+// over the output planes at once with multiplyAccumulate. The weights are transposed by transposeSquares.
+func convolutionFMA(inputPlanes []ImagePlane, W []float32, nOutputPlane int, bias []float32, s *scratch) []ImagePlane {
+	if len(inputPlanes) == 0 {
+		return nil
+	}
+	const square = 9
+	width := inputPlanes[0].Width
+	height := inputPlanes[0].Height
+	outputPlanes := s.outputPlanes(nOutputPlane, width-2, height-2)
+	segments := s.temp(0, len(inputPlanes)*square)
+	sumValues := s.temp(1, nOutputPlane)
+	for y := 1; y < height-1; y++ {
+		for x := 1; x < width-1; x++ {
+			for i := range inputPlanes {
+				seg := segments[i*square : (i+1)*square : (i+1)*square]
+				seg[0], seg[1], seg[2], seg[3], seg[4], seg[5], seg[6], seg[7], seg[8] = inputPlanes[i].SegmentAt(x, y)
+			}
+			copy(sumValues, bias)
+			multiplyAccumulate(sumValues, W, segments)
+			for o := 0; o < nOutputPlane; o++ {
+				v := sumValues[o]
+				if v < 0 {
+					v *= 0.1
+				}
+				outputPlanes[o].SetAt(x-1, y-1, v)
+			}
+		}
+	}
+	return outputPlanes
+}
+
+// multiplyAccumulateGeneric is multiplyAccumulate for the sums from the index from.
+func multiplyAccumulateGeneric(sum, w, segments []float32, from int) {
+	n := len(sum)
+	for j, s := range segments {
+		row := w[j*n+from : (j+1)*n]
+		for o, v := range row {
+			sum[from+o] += v * s
+		}
+	}
+}
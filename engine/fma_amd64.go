@@ -0,0 +1,121 @@
+package engine
+
+// useAVX2FMA reports whether the CPU and the OS support AVX2 and FMA.
+var useAVX2FMA = hasAVX2FMA()
+
+func cpuid(eaxArg, ecxArg uint32) (eax, ebx, ecx, edx uint32)
+
+func xgetbv() (eax, edx uint32)
+
+func hasAVX2FMA() bool {
+	maxID, _, _, _ := cpuid(0, 0)
+	if maxID < 7 {
+		return false
+	}
+	_, _, ecx1, _ := cpuid(1, 0)
+	const (
+		fma     = 1 << 12
+		osxsave = 1 << 27
+		avx     = 1 << 28
+	)
+	if ecx1&(fma|osxsave|avx) != fma|osxsave|avx {
+		return false
+	}
+	// the OS saves the XMM and the YMM registers
+	if xcr0, _ := xgetbv(); xcr0&0x6 != 0x6 {
+		return false
+	}
+	_, ebx7, _, _ := cpuid(7, 0)
+	const avx2 = 1 << 5
+	return ebx7&avx2 != 0
+}
+
+// fmaSegments accumulates w[j*n+o] * segments[j] for j < k to sum[o] for o < n rounded down to a multiple of 8,
+// where k > 0.
+//
+//go:noescape
+func fmaSegments(sum, w, segments *float32, k, n int)
+
+// multiplyAccumulate accumulates w[j*len(sum)+o] * segments[j] for all j to sum[o] for all o.
+func multiplyAccumulate(sum, w, segments []float32) {
+	n := len(sum) &^ 7
+	if !useAVX2FMA || n == 0 || len(segments) == 0 {
+		multiplyAccumulateGeneric(sum, w, segments, 0)
+		return
+	}
+	_ = w[len(segments)*len(sum)-1] // bounds check for the assembly
+	fmaSegments(&sum[0], &w[0], &segments[0], len(segments), len(sum))
+	multiplyAccumulateGeneric(sum, w, segments, n)
+}
+
+// gemmKernel4x24 accumulates the product of the 4 rows of a [4][k] and the 24 columns of b [k][24]
+// to c [4][24], where the rows are ldc, lda and ldb apart respectively and k > 0.
+//
+//go:noescape
+func gemmKernel4x24(c *float32, ldc int, a *float32, lda int, b *float32, ldb int, k int)
+
+// gemmKernel4x8 is gemmKernel4x24 for 8 columns.
+//
+//go:noescape
+func gemmKernel4x8(c *float32, ldc int, a *float32, lda int, b *float32, ldb int, k int)
+
+// gemmAccumulateFMA accumulates the product of the matrices a [m][k] and b [k][n] to the matrix c [m][n]
+// in the tiles of 4 rows and 24 or 8 columns, and returns the numbers of the rows and the columns computed.
+func gemmAccumulateFMA(c []float32, ldc int, a []float32, lda int, b []float32, ldb int, m, k, n int) (int, int) {
+	rows, cols := m&^3, n&^7
+	if !useAVX2FMA || rows == 0 || cols == 0 || k == 0 {
+		return 0, 0
+	}
+	// bounds checks for the assembly
+	_ = c[(rows-1)*ldc+cols-1]
+	_ = a[(rows-1)*lda+k-1]
+	_ = b[(k-1)*ldb+cols-1]
+	j := 0
+	for ; j+24 <= cols; j += 24 { // the columns of b stay in the cache for all the rows
+		for i := 0; i < rows; i += 4 {
+			gemmKernel4x24(&c[i*ldc+j], ldc, &a[i*lda], lda, &b[j], ldb, k)
+		}
+	}
+	for ; j < cols; j += 8 {
+		for i := 0; i < rows; i += 4 {
+			gemmKernel4x8(&c[i*ldc+j], ldc, &a[i*lda], lda, &b[j], ldb, k)
+		}
+	}
+	return rows, cols
+}
+
+// gemmInt16Kernel4x16 accumulates the product of the 4 rows of a [4][2k] and the 16 columns of b [k][16][2]
+// to c [4][16] with VPMADDWD, where the rows are ldc, lda and ldb apart respectively and k > 0.
+//
+//go:noescape
+func gemmInt16Kernel4x16(c *int32, ldc int, a *int16, lda int, b *int16, ldb int, k int)
+
+// gemmInt16Kernel4x8 is gemmInt16Kernel4x16 for 8 columns.
+//
+//go:noescape
+func gemmInt16Kernel4x8(c *int32, ldc int, a *int16, lda int, b *int16, ldb int, k int)
+
+// gemmAccumulateInt16AVX2 is gemmAccumulateInt16 in the tiles of 4 rows and 16 or 8 columns,
+// and returns the numbers of the rows and the columns computed.
+func gemmAccumulateInt16AVX2(c []int32, ldc int, a []int16, lda int, b []int16, ldb int, m, k, n int) (int, int) {
+	rows, cols := m&^3, n&^7
+	if !useAVX2FMA || rows == 0 || cols == 0 || k == 0 {
+		return 0, 0
+	}
+	// bounds checks for the assembly
+	_ = c[(rows-1)*ldc+cols-1]
+	_ = a[(rows-1)*lda+2*k-1]
+	_ = b[(k-1)*ldb+2*cols-1]
+	j := 0
+	for ; j+16 <= cols; j += 16 { // the columns of b stay in the cache for all the rows
+		for i := 0; i < rows; i += 4 {
+			gemmInt16Kernel4x16(&c[i*ldc+j], ldc, &a[i*lda], lda, &b[2*j], ldb, k)
+		}
+	}
+	for ; j < cols; j += 8 {
+		for i := 0; i < rows; i += 4 {
+			gemmInt16Kernel4x8(&c[i*ldc+j], ldc, &a[i*lda], lda, &b[2*j], ldb, k)
+		}
+	}
+	return rows, cols
+}
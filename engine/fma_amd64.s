@@ -0,0 +1,357 @@
+#include "textflag.h"
+
+// func cpuid(eaxArg, ecxArg uint32) (eax, ebx, ecx, edx uint32)
+TEXT ·cpuid(SB), NOSPLIT, $0-24
+	MOVL eaxArg+0(FP), AX
+	MOVL ecxArg+4(FP), CX
+	CPUID
+	MOVL AX, eax+8(FP)
+	MOVL BX, ebx+12(FP)
+	MOVL CX, ecx+16(FP)
+	MOVL DX, edx+20(FP)
+	RET
+
+// func xgetbv() (eax, edx uint32)
+TEXT ·xgetbv(SB), NOSPLIT, $0-8
+	MOVL $0, CX
+	XGETBV
+	MOVL AX, eax+0(FP)
+	MOVL DX, edx+4(FP)
+	RET
+
+// func fmaSegments(sum, w, segments *float32, k, n int)
+TEXT ·fmaSegments(SB), NOSPLIT, $0-40
+	MOVQ sum+0(FP), DI
+	MOVQ w+8(FP), SI
+	MOVQ segments+16(FP), DX
+	MOVQ k+24(FP), CX
+	MOVQ n+32(FP), R8
+	MOVQ R8, R9
+	SHLQ $2, R9 // stride of the rows of w in bytes
+	MOVQ R8, R10
+	ANDQ $-8, R10 // the columns to compute, a multiple of 8
+
+loop32:
+	// 32 columns with 4 accumulators
+	MOVQ R10, AX
+	SUBQ $32, AX
+	JL   loop8
+	VMOVUPS (DI), Y0
+	VMOVUPS 32(DI), Y1
+	VMOVUPS 64(DI), Y2
+	VMOVUPS 96(DI), Y3
+	MOVQ SI, R11
+	MOVQ DX, R12
+	MOVQ CX, R13
+
+inner32:
+	VBROADCASTSS (R12), Y4
+	VFMADD231PS (R11), Y4, Y0
+	VFMADD231PS 32(R11), Y4, Y1
+	VFMADD231PS 64(R11), Y4, Y2
+	VFMADD231PS 96(R11), Y4, Y3
+	ADDQ R9, R11
+	ADDQ $4, R12
+	DECQ R13
+	JNZ  inner32
+	VMOVUPS Y0, (DI)
+	VMOVUPS Y1, 32(DI)
+	VMOVUPS Y2, 64(DI)
+	VMOVUPS Y3, 96(DI)
+	ADDQ $128, DI
+	ADDQ $128, SI
+	MOVQ AX, R10
+	JMP  loop32
+
+loop8:
+	// 8 columns with an accumulator
+	CMPQ R10, $8
+	JL   done
+	VMOVUPS (DI), Y0
+	MOVQ SI, R11
+	MOVQ DX, R12
+	MOVQ CX, R13
+
+inner8:
+	VBROADCASTSS (R12), Y4
+	VFMADD231PS (R11), Y4, Y0
+	ADDQ R9, R11
+	ADDQ $4, R12
+	DECQ R13
+	JNZ  inner8
+	VMOVUPS Y0, (DI)
+	ADDQ $32, DI
+	ADDQ $32, SI
+	SUBQ $8, R10
+	JMP  loop8
+
+done:
+	VZEROUPPER
+	RET
+
+// func gemmKernel4x24(c *float32, ldc int, a *float32, lda int, b *float32, ldb int, k int)
+TEXT ·gemmKernel4x24(SB), NOSPLIT, $0-56
+	MOVQ c+0(FP), DI
+	MOVQ ldc+8(FP), R8
+	SHLQ $2, R8 // strides in bytes
+	MOVQ a+16(FP), SI
+	MOVQ lda+24(FP), R9
+	SHLQ $2, R9
+	MOVQ b+32(FP), DX
+	MOVQ ldb+40(FP), R10
+	SHLQ $2, R10
+	MOVQ k+48(FP), CX
+	LEAQ (SI)(R9*2), R11
+	ADDQ R9, R11 // the 4th row of a
+	VXORPS Y0, Y0, Y0
+	VXORPS Y1, Y1, Y1
+	VXORPS Y2, Y2, Y2
+	VXORPS Y3, Y3, Y3
+	VXORPS Y4, Y4, Y4
+	VXORPS Y5, Y5, Y5
+	VXORPS Y6, Y6, Y6
+	VXORPS Y7, Y7, Y7
+	VXORPS Y8, Y8, Y8
+	VXORPS Y9, Y9, Y9
+	VXORPS Y10, Y10, Y10
+	VXORPS Y11, Y11, Y11
+
+loop24:
+	// 4 rows x 24 columns with 12 accumulators
+	VMOVUPS (DX), Y12
+	VMOVUPS 32(DX), Y13
+	VMOVUPS 64(DX), Y14
+	VBROADCASTSS (SI), Y15
+	VFMADD231PS Y12, Y15, Y0
+	VFMADD231PS Y13, Y15, Y1
+	VFMADD231PS Y14, Y15, Y2
+	VBROADCASTSS (SI)(R9*1), Y15
+	VFMADD231PS Y12, Y15, Y3
+	VFMADD231PS Y13, Y15, Y4
+	VFMADD231PS Y14, Y15, Y5
+	VBROADCASTSS (SI)(R9*2), Y15
+	VFMADD231PS Y12, Y15, Y6
+	VFMADD231PS Y13, Y15, Y7
+	VFMADD231PS Y14, Y15, Y8
+	VBROADCASTSS (R11), Y15
+	VFMADD231PS Y12, Y15, Y9
+	VFMADD231PS Y13, Y15, Y10
+	VFMADD231PS Y14, Y15, Y11
+	ADDQ $4, SI
+	ADDQ $4, R11
+	ADDQ R10, DX
+	DECQ CX
+	JNZ  loop24
+
+	// accumulate to c
+	VADDPS (DI), Y0, Y0
+	VADDPS 32(DI), Y1, Y1
+	VADDPS 64(DI), Y2, Y2
+	VMOVUPS Y0, (DI)
+	VMOVUPS Y1, 32(DI)
+	VMOVUPS Y2, 64(DI)
+	ADDQ R8, DI
+	VADDPS (DI), Y3, Y3
+	VADDPS 32(DI), Y4, Y4
+	VADDPS 64(DI), Y5, Y5
+	VMOVUPS Y3, (DI)
+	VMOVUPS Y4, 32(DI)
+	VMOVUPS Y5, 64(DI)
+	ADDQ R8, DI
+	VADDPS (DI), Y6, Y6
+	VADDPS 32(DI), Y7, Y7
+	VADDPS 64(DI), Y8, Y8
+	VMOVUPS Y6, (DI)
+	VMOVUPS Y7, 32(DI)
+	VMOVUPS Y8, 64(DI)
+	ADDQ R8, DI
+	VADDPS (DI), Y9, Y9
+	VADDPS 32(DI), Y10, Y10
+	VADDPS 64(DI), Y11, Y11
+	VMOVUPS Y9, (DI)
+	VMOVUPS Y10, 32(DI)
+	VMOVUPS Y11, 64(DI)
+	VZEROUPPER
+	RET
+
+// func gemmKernel4x8(c *float32, ldc int, a *float32, lda int, b *float32, ldb int, k int)
+TEXT ·gemmKernel4x8(SB), NOSPLIT, $0-56
+	MOVQ c+0(FP), DI
+	MOVQ ldc+8(FP), R8
+	SHLQ $2, R8 // strides in bytes
+	MOVQ a+16(FP), SI
+	MOVQ lda+24(FP), R9
+	SHLQ $2, R9
+	MOVQ b+32(FP), DX
+	MOVQ ldb+40(FP), R10
+	SHLQ $2, R10
+	MOVQ k+48(FP), CX
+	LEAQ (SI)(R9*2), R11
+	ADDQ R9, R11 // the 4th row of a
+	VXORPS Y0, Y0, Y0
+	VXORPS Y1, Y1, Y1
+	VXORPS Y2, Y2, Y2
+	VXORPS Y3, Y3, Y3
+
+loop8:
+	// 4 rows x 8 columns with 4 accumulators
+	VMOVUPS (DX), Y12
+	VBROADCASTSS (SI), Y15
+	VFMADD231PS Y12, Y15, Y0
+	VBROADCASTSS (SI)(R9*1), Y15
+	VFMADD231PS Y12, Y15, Y1
+	VBROADCASTSS (SI)(R9*2), Y15
+	VFMADD231PS Y12, Y15, Y2
+	VBROADCASTSS (R11), Y15
+	VFMADD231PS Y12, Y15, Y3
+	ADDQ $4, SI
+	ADDQ $4, R11
+	ADDQ R10, DX
+	DECQ CX
+	JNZ  loop8
+
+	// accumulate to c
+	VADDPS (DI), Y0, Y0
+	VMOVUPS Y0, (DI)
+	ADDQ R8, DI
+	VADDPS (DI), Y1, Y1
+	VMOVUPS Y1, (DI)
+	ADDQ R8, DI
+	VADDPS (DI), Y2, Y2
+	VMOVUPS Y2, (DI)
+	ADDQ R8, DI
+	VADDPS (DI), Y3, Y3
+	VMOVUPS Y3, (DI)
+	VZEROUPPER
+	RET
+
+// func gemmInt16Kernel4x16(c *int32, ldc int, a *int16, lda int, b *int16, ldb int, k int)
+TEXT ·gemmInt16Kernel4x16(SB), NOSPLIT, $0-56
+	MOVQ c+0(FP), DI
+	MOVQ ldc+8(FP), R8
+	SHLQ $2, R8 // strides in bytes
+	MOVQ a+16(FP), SI
+	MOVQ lda+24(FP), R9
+	SHLQ $1, R9
+	MOVQ b+32(FP), DX
+	MOVQ ldb+40(FP), R10
+	SHLQ $1, R10
+	MOVQ k+48(FP), CX
+	LEAQ (SI)(R9*2), R11
+	ADDQ R9, R11 // the 4th row of a
+	VPXOR Y0, Y0, Y0
+	VPXOR Y1, Y1, Y1
+	VPXOR Y2, Y2, Y2
+	VPXOR Y3, Y3, Y3
+	VPXOR Y4, Y4, Y4
+	VPXOR Y5, Y5, Y5
+	VPXOR Y6, Y6, Y6
+	VPXOR Y7, Y7, Y7
+
+loopInt16x16:
+	// 4 rows x 16 columns with 8 accumulators, each VPMADDWD multiplies the pairs of 8 columns
+	VMOVDQU (DX), Y8
+	VMOVDQU 32(DX), Y9
+	VPBROADCASTD (SI), Y10
+	VPBROADCASTD (SI)(R9*1), Y11
+	VPMADDWD Y8, Y10, Y12
+	VPMADDWD Y9, Y10, Y13
+	VPMADDWD Y8, Y11, Y14
+	VPMADDWD Y9, Y11, Y15
+	VPADDD Y12, Y0, Y0
+	VPADDD Y13, Y1, Y1
+	VPADDD Y14, Y2, Y2
+	VPADDD Y15, Y3, Y3
+	VPBROADCASTD (SI)(R9*2), Y10
+	VPBROADCASTD (R11), Y11
+	VPMADDWD Y8, Y10, Y12
+	VPMADDWD Y9, Y10, Y13
+	VPMADDWD Y8, Y11, Y14
+	VPMADDWD Y9, Y11, Y15
+	VPADDD Y12, Y4, Y4
+	VPADDD Y13, Y5, Y5
+	VPADDD Y14, Y6, Y6
+	VPADDD Y15, Y7, Y7
+	ADDQ $4, SI
+	ADDQ $4, R11
+	ADDQ R10, DX
+	DECQ CX
+	JNZ  loopInt16x16
+
+	// accumulate to c
+	VPADDD (DI), Y0, Y0
+	VPADDD 32(DI), Y1, Y1
+	VMOVDQU Y0, (DI)
+	VMOVDQU Y1, 32(DI)
+	ADDQ R8, DI
+	VPADDD (DI), Y2, Y2
+	VPADDD 32(DI), Y3, Y3
+	VMOVDQU Y2, (DI)
+	VMOVDQU Y3, 32(DI)
+	ADDQ R8, DI
+	VPADDD (DI), Y4, Y4
+	VPADDD 32(DI), Y5, Y5
+	VMOVDQU Y4, (DI)
+	VMOVDQU Y5, 32(DI)
+	ADDQ R8, DI
+	VPADDD (DI), Y6, Y6
+	VPADDD 32(DI), Y7, Y7
+	VMOVDQU Y6, (DI)
+	VMOVDQU Y7, 32(DI)
+	VZEROUPPER
+	RET
+
+// func gemmInt16Kernel4x8(c *int32, ldc int, a *int16, lda int, b *int16, ldb int, k int)
+TEXT ·gemmInt16Kernel4x8(SB), NOSPLIT, $0-56
+	MOVQ c+0(FP), DI
+	MOVQ ldc+8(FP), R8
+	SHLQ $2, R8 // strides in bytes
+	MOVQ a+16(FP), SI
+	MOVQ lda+24(FP), R9
+	SHLQ $1, R9
+	MOVQ b+32(FP), DX
+	MOVQ ldb+40(FP), R10
+	SHLQ $1, R10
+	MOVQ k+48(FP), CX
+	LEAQ (SI)(R9*2), R11
+	ADDQ R9, R11 // the 4th row of a
+	VPXOR Y0, Y0, Y0
+	VPXOR Y1, Y1, Y1
+	VPXOR Y2, Y2, Y2
+	VPXOR Y3, Y3, Y3
+
+loopInt16x8:
+	// 4 rows x 8 columns with 4 accumulators
+	VMOVDQU (DX), Y8
+	VPBROADCASTD (SI), Y10
+	VPBROADCASTD (SI)(R9*1), Y11
+	VPBROADCASTD (SI)(R9*2), Y12
+	VPBROADCASTD (R11), Y13
+	VPMADDWD Y8, Y10, Y10
+	VPMADDWD Y8, Y11, Y11
+	VPMADDWD Y8, Y12, Y12
+	VPMADDWD Y8, Y13, Y13
+	VPADDD Y10, Y0, Y0
+	VPADDD Y11, Y1, Y1
+	VPADDD Y12, Y2, Y2
+	VPADDD Y13, Y3, Y3
+	ADDQ $4, SI
+	ADDQ $4, R11
+	ADDQ R10, DX
+	DECQ CX
+	JNZ  loopInt16x8
+
+	// accumulate to c
+	VPADDD (DI), Y0, Y0
+	VMOVDQU Y0, (DI)
+	ADDQ R8, DI
+	VPADDD (DI), Y1, Y1
+	VMOVDQU Y1, (DI)
+	ADDQ R8, DI
+	VPADDD (DI), Y2, Y2
+	VMOVDQU Y2, (DI)
+	ADDQ R8, DI
+	VPADDD (DI), Y3, Y3
+	VMOVDQU Y3, (DI)
+	VZEROUPPER
+	RET
@@ -0,0 +1,22 @@
+//go:build !amd64
+// +build !amd64
+
+package engine
+
+// useAVX2FMA reports whether the CPU and the OS support AVX2 and FMA.
+var useAVX2FMA = false
+
+// multiplyAccumulate accumulates w[j*len(sum)+o] * segments[j] for all j to sum[o] for all o.
+func multiplyAccumulate(sum, w, segments []float32) {
+	multiplyAccumulateGeneric(sum, w, segments, 0)
+}
+
+// gemmAccumulateFMA computes nothing without AVX2 and FMA.
+func gemmAccumulateFMA(c []float32, ldc int, a []float32, lda int, b []float32, ldb int, m, k, n int) (int, int) {
+	return 0, 0
+}
+
+// gemmAccumulateInt16AVX2 computes nothing without AVX2.
+func gemmAccumulateInt16AVX2(c []int32, ldc int, a []int16, lda int, b []int16, ldb int, m, k, n int) (int, int) {
+	return 0, 0
+}
@@ -0,0 +1,26 @@
+package engine
+
+import "testing"
+
+func Test_convolutionFMA(t *testing.T) {
+	if !useAVX2FMA {
+		t.Skip("AVX2 and FMA are not supported")
+	}
+	for _, nOutputPlane := range []int{3, 8, 45, 128} { // 45 = 32 + 8 + 5
+		m := randomModel(1, []int{7, nOutputPlane}, []int{3}).fmaWeights()
+		in := randomPlanes(2, 7, 13, 11)
+		want := convolution(in, m[0].WeightVec, m[0].NOutputPlane, m[0].Bias, nil)
+		got := convolutionFMA(in, m[0].weightFMA, m[0].NOutputPlane, m[0].Bias, nil)
+		assertPlanesNear(t, want, got, 1e-6)
+	}
+}
+
+func Test_multiplyAccumulate(t *testing.T) {
+	planes := randomPlanes(1, 3, 45, 9)
+	sum, w, segments := planes[0].Buffer[:45], planes[1].Buffer, planes[2].Buffer[:9]
+	want := make([]float32, len(sum))
+	copy(want, sum)
+	multiplyAccumulateGeneric(want, w, segments, 0)
+	multiplyAccumulate(sum, w, segments)
+	assertPlanesNear(t, []ImagePlane{{Width: 45, Height: 1, Buffer: want}}, []ImagePlane{{Width: 45, Height: 1, Buffer: sum}}, 1e-6)
+}
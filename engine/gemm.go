@@ -0,0 +1,144 @@
+package engine
+
+const (
+	gemmBlockN = 256 // output pixels of a panel
+	gemmBlockK = 128 // rows of a panel multiplied at once
+)
+
+// gemmWeights returns the copy of the model whose convolution layers have the weights for the GEMM backend,
+// i.e. a row of the weights for each output plane, instead of WeightVec.
+func (m Model) gemmWeights() Model {
+	if m == nil {
+		return nil
+	}
+	ret := make(Model, len(m))
+	for l, p := range m {
+		if p.Type() == Convolution && p.WeightVec != nil && p.weightInt8 == nil {
+			p.weightGEMM = p.prepare(preparedGEMM, func() interface{} {
+				return transposeWeights(p.WeightVec, p.NInputPlane, p.NOutputPlane, p.KW*p.KH)
+			}).([]float32)
+			p.WeightVec = nil
+			p.Weight = nil
+		}
+		ret[l] = p
+	}
+	return ret
+}
+
+// transposeWeights converts the weights [nInputPlane][nOutputPlane][square] to [nOutputPlane][nInputPlane*square].
+func transposeWeights(w []float32, nInputPlane, nOutputPlane, square int) []float32 {
+	ret := make([]float32, len(w))
+	for i := 0; i < nInputPlane; i++ {
+		for o := 0; o < nOutputPlane; o++ {
+			copy(ret[(o*nInputPlane+i)*square:(o*nInputPlane+i+1)*square], w[(i*nOutputPlane+o)*square:(i*nOutputPlane+o+1)*square])
+		}
+	}
+	return ret
+}
+
+// convolutionGEMM is the convolution of the k x k kernel as the matrix multiplication of the weights
+// [nOutputPlane][nInputPlane*k*k] and the patches [nInputPlane*k*k][pixels], where k is odd.
+// The patches are laid out by panels of gemmBlockN pixels, and the panel is multiplied by gemmBlockK rows
+// so that the part of the panel stays in the cache.
+func convolutionGEMM(inputPlanes []ImagePlane, W []float32, nOutputPlane int, bias []float32, k int, s *scratch) []ImagePlane {
+	if len(inputPlanes) == 0 {
+		return nil
+	}
+	width := inputPlanes[0].Width - (k - 1)
+	height := inputPlanes[0].Height - (k - 1)
+	outputPlanes := s.outputPlanes(nOutputPlane, width, height)
+	rows := len(inputPlanes) * k * k
+	pixels := width * height
+	panel := s.temp(0, rows*gemmBlockN)
+	sums := s.temp(1, nOutputPlane*gemmBlockN)
+	for n0 := 0; n0 < pixels; n0 += gemmBlockN {
+		n := gemmBlockN
+		if n0+n > pixels {
+			n = pixels - n0
+		}
+		im2col(panel[:rows*n], inputPlanes, k, width, n0, n)
+		c := sums[:nOutputPlane*n]
+		for o := 0; o < nOutputPlane; o++ {
+			for j := range c[o*n : (o+1)*n] {
+				c[o*n+j] = bias[o]
+			}
+		}
+		for k0 := 0; k0 < rows; k0 += gemmBlockK {
+			k1 := k0 + gemmBlockK
+			if k1 > rows {
+				k1 = rows
+			}
+			gemmAccumulate(c, n, W[k0:], rows, panel[k0*n:], n, nOutputPlane, k1-k0, n)
+		}
+		for o := 0; o < nOutputPlane; o++ {
+			out := outputPlanes[o].Buffer[n0 : n0+n]
+			for j, v := range c[o*n : (o+1)*n] {
+				if v < 0 {
+					v *= 0.1
+				}
+				out[j] = v
+			}
+		}
+	}
+	return outputPlanes
+}
+
+// im2col lays out the k x k patches of the n output pixels from the pixel n0 to the panel [nInputPlane*k*k][n].
+func im2col(panel []float32, inputPlanes []ImagePlane, k, width, n0, n int) {
+	r := 0
+	for _, p := range inputPlanes {
+		for ky := 0; ky < k; ky++ {
+			for kx := 0; kx < k; kx++ {
+				row := panel[r*n : (r+1)*n]
+				// the pixels of an output row are contiguous in the input row
+				for j, x, y := 0, n0%width, n0/width; j < n; j, x, y = j+width-x, 0, y+1 {
+					i := (y+ky)*p.Width + x + kx
+					copy(row[j:], p.Buffer[i:i+width-x])
+				}
+				r++
+			}
+		}
+	}
+}
+
+// gemmAccumulate accumulates the product of the matrices a [m][k] and b [k][n] to the matrix c [m][n],
+// whose rows are ldc, lda and ldb apart respectively.
+func gemmAccumulate(c []float32, ldc int, a []float32, lda int, b []float32, ldb int, m, k, n int) {
+	rows, cols := gemmAccumulateFMA(c, ldc, a, lda, b, ldb, m, k, n)
+	gemmAccumulateGeneric(c, ldc, a, lda, b, ldb, rows, k, cols, n)
+	if rows < m {
+		gemmAccumulateGeneric(c[rows*ldc:], ldc, a[rows*lda:], lda, b, ldb, m-rows, k, 0, n)
+	}
+}
+
+// gemmAccumulateGeneric is gemmAccumulate for the columns from j0 to j1.
+func gemmAccumulateGeneric(c []float32, ldc int, a []float32, lda int, b []float32, ldb int, m, k, j0, j1 int) {
+	if j0 == j1 {
+		return
+	}
+	i := 0
+	for ; i+4 <= m; i += 4 {
+		c0 := c[i*ldc+j0 : i*ldc+j1]
+		c1 := c[(i+1)*ldc+j0 : (i+1)*ldc+j1][:len(c0)] // the same lengths eliminate the bounds checks
+		c2 := c[(i+2)*ldc+j0 : (i+2)*ldc+j1][:len(c0)]
+		c3 := c[(i+3)*ldc+j0 : (i+3)*ldc+j1][:len(c0)]
+		for r := 0; r < k; r++ {
+			w0, w1, w2, w3 := a[i*lda+r], a[(i+1)*lda+r], a[(i+2)*lda+r], a[(i+3)*lda+r]
+			for j, v := range b[r*ldb+j0 : r*ldb+j1][:len(c0)] {
+				c0[j] += w0 * v
+				c1[j] += w1 * v
+				c2[j] += w2 * v
+				c3[j] += w3 * v
+			}
+		}
+	}
+	for ; i < m; i++ {
+		ci := c[i*ldc+j0 : i*ldc+j1]
+		for r := 0; r < k; r++ {
+			w := a[i*lda+r]
+			for j, v := range b[r*ldb+j0 : r*ldb+j1][:len(ci)] {
+				ci[j] += w * v
+			}
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package engine
+
+import (
+	"fmt"
+	"testing"
+)
+
+func Test_convolutionGEMM(t *testing.T) {
+	m := randomModel(1, []int{16, 8, 4}, []int{3, 5})
+	in := randomPlanes(2, 16, 23, 19) // 21x17 output pixels, not a multiple of the panel
+	want := convolution(in, m[0].WeightVec, m[0].NOutputPlane, m[0].Bias, nil)
+	w := transposeWeights(m[0].WeightVec, m[0].NInputPlane, m[0].NOutputPlane, 9)
+	got := convolutionGEMM(in, w, m[0].NOutputPlane, m[0].Bias, 3, nil)
+	assertPlanesNear(t, want, got, 1e-6)
+
+	in = want
+	want = convolutionKernel(in, m[1].WeightVec, m[1].NOutputPlane, m[1].Bias, 5, nil)
+	w = transposeWeights(m[1].WeightVec, m[1].NInputPlane, m[1].NOutputPlane, 25)
+	got = convolutionGEMM(in, w, m[1].NOutputPlane, m[1].Bias, 5, nil)
+	assertPlanesNear(t, want, got, 1e-6)
+}
+
+func Test_gemmAccumulate(t *testing.T) {
+	// 7 rows = 4 + 3, 45 columns = 24 + 8 + 8 + 5 in the matrices of wider rows
+	const m, k, n, ld = 7, 13, 45, 50
+	planes := randomPlanes(1, 3, ld, m*k)
+	a, b := planes[0].Buffer, planes[1].Buffer
+	want := make([]float32, m*ld)
+	copy(want, planes[2].Buffer)
+	got := make([]float32, m*ld)
+	copy(got, want)
+	for i := 0; i < m; i++ {
+		for r := 0; r < k; r++ {
+			for j := 0; j < n; j++ {
+				want[i*ld+j] += a[i*ld+r] * b[r*ld+j]
+			}
+		}
+	}
+	gemmAccumulate(got, ld, a, ld, b, ld, m, k, n)
+	assertPlanesNear(t, []ImagePlane{{Width: ld, Height: m, Buffer: want}}, []ImagePlane{{Width: ld, Height: m, Buffer: got}}, 1e-5)
+}
+
+func Benchmark_convolution(b *testing.B) {
+	for _, planes := range []int{32, 128} {
+		m := randomModel(1, []int{planes, planes}, []int{3})
+		in := randomPlanes(2, planes, 64, 64)
+		b.Run(fmt.Sprintf("direct_%d", planes), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				convolution(in, m[0].WeightVec, m[0].NOutputPlane, m[0].Bias, nil)
+			}
+		})
+		b.Run(fmt.Sprintf("fma_%d", planes), func(b *testing.B) {
+			if !useAVX2FMA {
+				b.Skip("AVX2 and FMA are not supported")
+			}
+			w := transposeSquares(m[0].WeightVec, m[0].NInputPlane, m[0].NOutputPlane, 9)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				convolutionFMA(in, w, m[0].NOutputPlane, m[0].Bias, nil)
+			}
+		})
+		b.Run(fmt.Sprintf("gemm_%d", planes), func(b *testing.B) {
+			w := transposeWeights(m[0].WeightVec, m[0].NInputPlane, m[0].NOutputPlane, 9)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				convolutionGEMM(in, w, m[0].NOutputPlane, m[0].Bias, 3, nil)
+			}
+		})
+		b.Run(fmt.Sprintf("int8_%d", planes), func(b *testing.B) {
+			q := quantizeWeights(m[0].WeightVec, m[0].NOutputPlane, 1)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				convolutionInt8(in, q, m[0].NOutputPlane, m[0].Bias, nil)
+			}
+		})
+		b.Run(fmt.Sprintf("winograd_%d", planes), func(b *testing.B) {
+			U := transformWinogradWeights(m[0].WeightVec, m[0].NInputPlane, m[0].NOutputPlane)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				convolutionWinograd(in, U, m[0].NOutputPlane, m[0].Bias, nil)
+			}
+		})
+	}
+}
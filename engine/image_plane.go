@@ -6,9 +6,11 @@ import (
 )
 
 const (
-	// BlockSize is the size of a block when splitting the image plane.
+	// BlockSize is the default size of a block when splitting the image plane.
 	BlockSize = 128
-	// Overlap is the size of the pixels that the image overlaps.
+	// Overlap is the size of the pixels that the image overlaps for the 7-layer models.
+	//
+	// Deprecated: the overlap depends on the model, use Model.Overlap instead.
 	Overlap = 14
 )
 
@@ -75,15 +77,21 @@ func (p *ImagePlane) SetAt(width, height int, v float32) {
 }
 
 // Blocking divides a given image into blocks.
+//
+// Deprecated: the block size and the overlap depend on the options and the model, use BlockingOverlap instead.
 func Blocking(initialPlanes [3]ImagePlane) ([][]ImagePlane, int, int) {
+	return BlockingOverlap(initialPlanes[:], BlockSize, Overlap)
+}
+
+// BlockingOverlap divides a given image into blocks of the block size which overlap each other by the overlap pixels.
+func BlockingOverlap(initialPlanes []ImagePlane, blockSize, overlap int) ([][]ImagePlane, int, int) {
 	widthInput := initialPlanes[0].Width
 	heightInput := initialPlanes[0].Height
-	// blocks overlap 14px each other.
-	blocksW := int(math.Ceil(float64(widthInput-Overlap) / float64(BlockSize-Overlap)))
-	blocksH := int(math.Ceil(float64(heightInput-Overlap) / float64(BlockSize-Overlap)))
+	blocksW := int(math.Ceil(float64(widthInput-overlap) / float64(blockSize-overlap)))
+	blocksH := int(math.Ceil(float64(heightInput-overlap) / float64(blockSize-overlap)))
 	blocks := blocksW * blocksH
 
-	// fmt.Println("BlockSize:", BlockSize)
+	// fmt.Println("blockSize:", blockSize)
 	// fmt.Printf("blocksW:%d, blocksH:%d, blocks:%d\n", blocksW, blocksH, blocks)
 
 	inputBlocks := make([][]ImagePlane, blocks) // [ [ block0_R, block0_G, block0_B ], [ block1_R, ...] ... ]
@@ -93,14 +101,14 @@ func Blocking(initialPlanes [3]ImagePlane) ([][]ImagePlane, int, int) {
 
 		// fmt.Printf("blockIndexW:%d, blockIndexH:%d\n", blockIndexW, blockIndexH)
 
-		blockWidth := BlockSize
-		blockHeight := BlockSize
+		blockWidth := blockSize
+		blockHeight := blockSize
 
 		if blockIndexW == blocksW-1 {
-			blockWidth = widthInput - ((BlockSize - Overlap) * blockIndexW) // right end block
+			blockWidth = widthInput - ((blockSize - overlap) * blockIndexW) // right end block
 		}
 		if blockIndexH == blocksH-1 {
-			blockHeight = heightInput - ((BlockSize - Overlap) * blockIndexH) // bottom end block
+			blockHeight = heightInput - ((blockSize - overlap) * blockIndexH) // bottom end block
 		}
 
 		// fmt.Printf("\t>>blockWidth:%d, blockHeight:%d\n", blockWidth, blockHeight)
@@ -113,8 +121,8 @@ func Blocking(initialPlanes [3]ImagePlane) ([][]ImagePlane, int, int) {
 		for w := 0; w < blockWidth; w++ {
 			for h := 0; h < blockHeight; h++ {
 				for i := 0; i < len(initialPlanes); i++ {
-					targetIndexW := blockIndexW*(BlockSize-Overlap) + w
-					targetIndexH := blockIndexH*(BlockSize-Overlap) + h
+					targetIndexW := blockIndexW*(blockSize-overlap) + w
+					targetIndexH := blockIndexH*(blockSize-overlap) + h
 					channel := initialPlanes[i]
 					v := channel.Value(targetIndexW, targetIndexH)
 					channels[i].SetAt(w, h, v)
@@ -127,8 +135,20 @@ func Blocking(initialPlanes [3]ImagePlane) ([][]ImagePlane, int, int) {
 }
 
 // Deblocking combines blocks for each of the R, G, and B channels.
+//
+// Deprecated: the models can have other than 3 channels, use DeblockingPlanes instead.
 func Deblocking(outputBlocks [][]ImagePlane, blocksW, blocksH int) [3]ImagePlane {
-	blockSize := outputBlocks[0][0].Width
+	var ret [3]ImagePlane
+	copy(ret[:], DeblockingPlanes(outputBlocks, blocksW, blocksH))
+	return ret
+}
+
+// DeblockingPlanes combines blocks for each channel.
+func DeblockingPlanes(outputBlocks [][]ImagePlane, blocksW, blocksH int) []ImagePlane {
+	// the first block has the full size unless it is also the last one in that direction.
+	blockWidth := outputBlocks[0][0].Width
+	blockHeight := outputBlocks[0][0].Height
+
 	var width int
 	for b := 0; b < blocksW; b++ {
 		width += outputBlocks[b][0].Width
@@ -139,21 +159,20 @@ func Deblocking(outputBlocks [][]ImagePlane, blocksW, blocksH int) [3]ImagePlane
 		height += outputBlocks[b][0].Height
 	}
 
-	var outputPlanes [3]ImagePlane // R,G,B
+	outputPlanes := make([]ImagePlane, len(outputBlocks[0]))
+	for i := range outputPlanes {
+		outputPlanes[i] = NewImagePlaneWidthHeight(width, height)
+	}
 	for b := range outputBlocks {
-		block := outputBlocks[b]
 		blockIndexW := b % blocksW
 		blockIndexH := int(math.Floor(float64(b) / float64(blocksW)))
 
-		for i := 0; i < len(block); i++ {
-			if len(outputPlanes[i].Buffer) == 0 {
-				outputPlanes[i] = NewImagePlaneWidthHeight(width, height)
-			}
-			channelBlock := block[i]
+		for i := 0; i < len(outputBlocks[b]); i++ {
+			channelBlock := outputBlocks[b][i]
 			for w := 0; w < channelBlock.Width; w++ {
 				for h := 0; h < channelBlock.Height; h++ {
-					targetIndexW := blockIndexW*blockSize + w
-					targetIndexH := blockIndexH*blockSize + h
+					targetIndexW := blockIndexW*blockWidth + w
+					targetIndexH := blockIndexH*blockHeight + h
 					targetIndex := targetIndexH*width + targetIndexW
 					v := channelBlock.Value(w, h)
 					outputPlanes[i].Buffer[targetIndex] = v
@@ -3,33 +3,160 @@ package engine
 import (
 	"embed"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
+	"path"
+	"sync"
 )
 
 // Param represents a parameter of the model.
 type Param struct {
+	ClassName    string          `json:"class_name"`   // レイヤの種類
 	Bias         []float32       `json:"bias"`         // バイアス
 	KW           int             `json:"kW"`           // フィルタの幅
 	KH           int             `json:"kH"`           // フィルタの高さ
+	DW           int             `json:"dW"`           // 横方向のストライド
+	DH           int             `json:"dH"`           // 縦方向のストライド
+	PadW         int             `json:"padW"`         // 横方向のパディング
+	PadH         int             `json:"padH"`         // 縦方向のパディング
 	Weight       [][][][]float32 `json:"weight"`       // 重み
 	NInputPlane  int             `json:"nInputPlane"`  // 入力平面数
 	NOutputPlane int             `json:"nOutputPlane"` // 出力平面数
 	WeightVec    []float32
+
+	// the weights prepared for the options of the inference
+	weightHalf     []uint16     // WeightVec in half precision, see InferencePrecision
+	weightInt8     *int8Weights // WeightVec quantized to int8, see QuantizeModel
+	weightGEMM     []float32    // WeightVec of [nOutputPlane][nInputPlane*kH*kW], see ConvolutionBackend
+	weightWinograd []float32    // WeightVec transformed for the Winograd minimal filtering, see ConvolutionBackend
+	weightFMA      []float32    // WeightVec of [nInputPlane][kH*kW][nOutputPlane] for AVX2 and FMA
+	prepared       *preparedWeights
+}
+
+// the kinds of the prepared weights
+const (
+	preparedHalf = iota
+	preparedGEMM
+	preparedWinograd
+	preparedFMA
+	preparedKinds
+)
+
+// preparedWeights is the weights of a loaded layer prepared from WeightVec, which are built lazily
+// and shared by the copies of the layer, e.g. the engines using a cached model.
+type preparedWeights struct {
+	once    [preparedKinds]sync.Once
+	weights [preparedKinds]interface{}
+}
+
+// prepare returns the weights of the kind built by the function once for the layer and its copies.
+// The layer not loaded by the loaders, e.g. made by hand, builds them every time.
+func (p Param) prepare(kind int, build func() interface{}) interface{} {
+	w := p.prepared
+	if w == nil {
+		return build()
+	}
+	w.once[kind].Do(func() {
+		w.weights[kind] = build()
+	})
+	return w.weights[kind]
+}
+
+// LayerType is the type of a layer.
+type LayerType int
+
+const (
+	// Convolution is the convolution layer, the weight is [nOutputPlane][nInputPlane][kH][kW].
+	Convolution LayerType = iota + 1
+	// Deconvolution is the transposed convolution layer, the weight is [nInputPlane][nOutputPlane][kH][kW].
+	Deconvolution
+)
+
+const (
+	convolutionClassName   = "nn.SpatialConvolutionMM"
+	deconvolutionClassName = "nn.SpatialFullConvolution"
+)
+
+// Type returns the type of the layer. The layer without a class name is a convolution.
+func (p Param) Type() LayerType {
+	switch p.ClassName {
+	case "", convolutionClassName, "nn.SpatialConvolution":
+		return Convolution
+	case deconvolutionClassName:
+		return Deconvolution
+	}
+	return 0
+}
+
+// stride returns the stride of the layer, the default is 1.
+func (p Param) stride() int {
+	if p.DW == 0 {
+		return 1
+	}
+	return p.DW
+}
+
+// kernel returns the kernel between the input plane and the output plane.
+func (p Param) kernel(i, o int) [][]float32 {
+	if p.Type() == Deconvolution {
+		return p.Weight[i][o]
+	}
+	return p.Weight[o][i]
 }
 
 // Model represents a trained model.
 type Model []Param
 
-// LoadModelFile loads a trained model from the specified file.
+// geometry returns the factor a and the border b of the output size a*n-b of the model for the input size n.
+func (m Model) geometry() (a, b int) {
+	a = 1
+	for _, p := range m {
+		switch p.Type() {
+		case Convolution:
+			// a kW x kH convolution shrinks a block by (kW-1)/2 pixels on each side.
+			b += p.KW - 1
+		case Deconvolution:
+			// (n-1)*s - 2*pad + k
+			s := p.stride()
+			a *= s
+			b = b*s + s + 2*p.PadW - p.KW
+		}
+	}
+	return a, b
+}
+
+// Scale returns the magnification of the model itself, i.e. 1 unless the model has deconvolution layers.
+func (m Model) Scale() int {
+	a, _ := m.geometry()
+	return a
+}
+
+// Overlap returns the size of the pixels by which adjacent input blocks must overlap,
+// that is the border which the layers shrink a block by measured in the input pixels.
+func (m Model) Overlap() int {
+	a, b := m.geometry()
+	return b / a
+}
+
+// InputPlanes returns the number of planes which the model takes, i.e. 3 for RGB models and 1 for Y models.
+func (m Model) InputPlanes() int {
+	if len(m) == 0 {
+		return 0
+	}
+	return m[0].NInputPlane
+}
+
+// LoadModelFile loads a trained model from the specified file in JSON or in the binary model format.
 func LoadModelFile(path string) (Model, error) {
 	fp, err := os.Open(path)
 	if err != nil {
 		return nil, err
 	}
 	defer fp.Close()
-	return LoadModel(fp)
+	return loadModelAuto(fp)
 }
 
 // LoadModel loads a trained model from the io.Reader.
@@ -39,31 +166,55 @@ func LoadModel(r io.Reader) (Model, error) {
 	if err := dec.Decode(&m); err != nil {
 		return nil, err
 	}
+	if err := m.validate(); err != nil {
+		return nil, err
+	}
 	m.setWeightVec()
 	return m, nil
 }
 
-//go:embed model/anime_style_art_rgb/* model/photo/*
-var assets embed.FS
-
-// LoadModelAssets loads a trained model from assets.
-func LoadModelAssets(path string) (Model, error) {
-	fsys, err := assets.Open(path)
+// LoadModelFS loads a trained model from the file of the file system in JSON or in the binary model format.
+func LoadModelFS(fsys fs.FS, path string) (Model, error) {
+	fp, err := fsys.Open(path)
 	if err != nil {
 		return nil, err
 	}
-	model, err := LoadModel(fsys)
+	defer fp.Close()
+	model, err := loadModelAuto(fp)
 	if err != nil {
-		return nil, fmt.Errorf("failed to load : %w", err)
+		return nil, fmt.Errorf("failed to load %s: %w", path, err)
 	}
 	return model, nil
 }
 
+//go:generate sh -c "for f in model/*/*.json; do go run .. convert-model -i $DOLLAR{f} -o $DOLLAR{f%.json}.bin || exit 1; done"
+
+// assets has the trained models in the binary model format, converted from the JSON ones by go generate,
+// which are loaded by default, and the JSON ones as the fallback, see loadModelName.
+//
+//go:embed model/anime_style_art/* model/anime_style_art_rgb/* model/photo/* model/ukbench/*
+var assets embed.FS
+
+// LoadModelAssets loads a trained model from assets.
+func LoadModelAssets(path string) (Model, error) {
+	return LoadModelFS(assets, path)
+}
+
 const (
-	animeModelPath     = `model/anime_style_art_rgb/scale2.0x_model.json`
-	animeNoisePathTmpl = `model/anime_style_art_rgb/noise%d_model.json`
-	photoModelPath     = `model/photo/scale2.0x_model.json`
-	photoNoisePathTmpl = `model/photo/noise%d_model.json`
+	// ScaleModelName is the file name without the extension of the scale model in a model directory.
+	ScaleModelName = `scale2.0x_model`
+	// NoiseModelNameTmpl is the template of the file name without the extension of the noise model in a model directory.
+	NoiseModelNameTmpl = `noise%d_model`
+)
+
+// modelFileExts is the extensions of the model files in the order of priority.
+var modelFileExts = []string{".bin", ".json"}
+
+const (
+	animeModelDir   = `model/anime_style_art_rgb`
+	photoModelDir   = `model/photo`
+	animeYModelDir  = `model/anime_style_art`
+	ukbenchModelDir = `model/ukbench`
 )
 
 // Mode is the type of trained models.
@@ -74,6 +225,10 @@ const (
 	Anime Mode = iota + 1
 	// Photo model type.
 	Photo
+	// AnimeY is the anime model type which processes the luminance only.
+	AnimeY
+	// UKBench is the photo model type which processes the luminance only. It has no noise reduction models.
+	UKBench
 )
 
 // String returns string representation of a mode.
@@ -83,6 +238,10 @@ func (t Mode) String() string {
 		return "anime"
 	case Photo:
 		return "photo"
+	case AnimeY:
+		return "anime_y"
+	case UKBench:
+		return "ukbench"
 	}
 	return fmt.Sprintf("unknown type=%d", t)
 }
@@ -94,59 +253,250 @@ type ModelSet struct {
 }
 
 // NewAssetModelSet returns a set of trained models loaded from assets.
+// The models are cached in DefaultModelCache and shared between the model sets, so they must not be modified.
 func NewAssetModelSet(t Mode, noiseLevel int) (*ModelSet, error) {
+	dir, err := assetModelDir(t, noiseLevel)
+	if err != nil {
+		return nil, err
+	}
+	return loadModelSet(loadCachedModelAssets, dir, noiseLevel, true)
+}
+
+// NewAssetNoiseModelSet returns a set of the noise model loaded from assets without the scale model,
+// which de-noises images without scaling them up, see NewAssetModelSet.
+func NewAssetNoiseModelSet(t Mode, noiseLevel int) (*ModelSet, error) {
+	if noiseLevel < 1 || noiseLevel > 3 {
+		return nil, fmt.Errorf("invalid noise level: 1...3 but %d", noiseLevel)
+	}
+	dir, err := assetModelDir(t, noiseLevel)
+	if err != nil {
+		return nil, err
+	}
+	return loadModelSet(loadCachedModelAssets, dir, noiseLevel, false)
+}
+
+// assetModelDir returns the directory of the models of the mode in assets.
+func assetModelDir(t Mode, noiseLevel int) (string, error) {
 	if noiseLevel < 0 || noiseLevel > 3 {
-		return nil, fmt.Errorf("invalid noise level: 0...3 but %d", noiseLevel)
+		return "", fmt.Errorf("invalid noise level: 0...3 but %d", noiseLevel)
 	}
-	var modelPath, noiseT string
 	switch t {
 	case Anime:
-		modelPath, noiseT = animeModelPath, animeNoisePathTmpl
+		return animeModelDir, nil
 	case Photo:
-		modelPath, noiseT = photoModelPath, photoNoisePathTmpl
-	default:
-		return nil, fmt.Errorf("unknown model type error")
+		return photoModelDir, nil
+	case AnimeY:
+		return animeYModelDir, nil
+	case UKBench:
+		if noiseLevel > 0 {
+			return "", fmt.Errorf("%v has no noise reduction models", t)
+		}
+		return ukbenchModelDir, nil
+	}
+	return "", fmt.Errorf("unknown model type error")
+}
+
+// loadCachedModelAssets loads a trained model from assets through DefaultModelCache.
+func loadCachedModelAssets(path string) (Model, error) {
+	return DefaultModelCache.Load("assets:"+path, func() (Model, error) {
+		return LoadModelAssets(path)
+	})
+}
+
+// loadCachedHalfModelAssets loads a trained model from assets through DefaultModelCache,
+// whose weights are stored only in half precision, see InferencePrecision.
+func loadCachedHalfModelAssets(path string) (Model, error) {
+	return DefaultModelCache.Load("assets:"+path+":float16", func() (Model, error) {
+		m, err := LoadModelAssets(path)
+		if err != nil {
+			return nil, err
+		}
+		return m.halfWeights(), nil
+	})
+}
+
+// LoadModelSet returns a set of trained models loaded from the directory of the file system.
+// The directory has the scale model named ScaleModelName and the noise models named NoiseModelNameTmpl
+// with the extension .bin for the binary model format or .json, the binary one takes priority.
+// The noise model is not loaded if the noise level is 0.
+func LoadModelSet(fsys fs.FS, dir string, noiseLevel int) (*ModelSet, error) {
+	return loadModelSet(func(path string) (Model, error) {
+		return LoadModelFS(fsys, path)
+	}, dir, noiseLevel, true)
+}
+
+// loadModelSet loads the noise model of the level if it is not 0, and the scale model if scale is true.
+func loadModelSet(load func(path string) (Model, error), dir string, noiseLevel int, scale bool) (*ModelSet, error) {
+	if noiseLevel < 0 {
+		return nil, fmt.Errorf("invalid noise level: %d", noiseLevel)
 	}
 	var noise Model
 	if noiseLevel > 0 {
 		var err error
-		noise, err = LoadModelAssets(fmt.Sprintf(noiseT, noiseLevel))
+		noise, err = loadModelName(load, dir, fmt.Sprintf(NoiseModelNameTmpl, noiseLevel))
 		if err != nil {
 			return nil, fmt.Errorf("load noise model error: %w", err)
 		}
 	}
-	scale, err := LoadModelAssets(modelPath)
+	ret := &ModelSet{NoiseModel: noise}
+	if scale {
+		var err error
+		ret.Scale2xModel, err = loadModelName(load, dir, ScaleModelName)
+		if err != nil {
+			return nil, fmt.Errorf("load scale model error: %w", err)
+		}
+	}
+	return ret, nil
+}
+
+// loadModelName loads the model of the name from the directory, trying the extensions of the model files.
+func loadModelName(load func(path string) (Model, error), dir, name string) (Model, error) {
+	var err error
+	for _, ext := range modelFileExts {
+		var m Model
+		m, err = load(path.Join(dir, name+ext))
+		if !errors.Is(err, fs.ErrNotExist) {
+			return m, err
+		}
+	}
+	return nil, err
+}
+
+// LoadModelSetDir returns a set of trained models loaded from the directory, see LoadModelSet.
+func LoadModelSetDir(dir string, noiseLevel int) (*ModelSet, error) {
+	m, err := LoadModelSet(os.DirFS(dir), ".", noiseLevel)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", dir, err)
+	}
+	return m, nil
+}
+
+// LoadNoiseModelSetDir returns a set of the noise model loaded from the directory without the scale model,
+// which de-noises images without scaling them up, see LoadModelSet.
+func LoadNoiseModelSetDir(dir string, noiseLevel int) (*ModelSet, error) {
+	if noiseLevel <= 0 {
+		return nil, fmt.Errorf("invalid noise level: %d", noiseLevel)
+	}
+	m, err := loadModelSet(func(path string) (Model, error) {
+		return LoadModelFS(os.DirFS(dir), path)
+	}, ".", noiseLevel, false)
 	if err != nil {
-		return nil, fmt.Errorf("load scale model error: %w", err)
+		return nil, fmt.Errorf("%s: %w", dir, err)
 	}
-	return &ModelSet{
-		Scale2xModel: scale,
-		NoiseModel:   noise,
-	}, nil
+	return m, nil
+}
+
+// validate checks the shapes of the parameters and that the layers are connected.
+func (m Model) validate() error {
+	if len(m) == 0 {
+		return fmt.Errorf("model has no layers")
+	}
+	for l, p := range m {
+		if l > 0 && p.NInputPlane != m[l-1].NOutputPlane {
+			return fmt.Errorf("layer %d: nInputPlane=%d does not match nOutputPlane=%d of the previous layer", l, p.NInputPlane, m[l-1].NOutputPlane)
+		}
+		if err := p.validate(); err != nil {
+			return fmt.Errorf("layer %d: %w", l, err)
+		}
+	}
+	if in, out := m.InputPlanes(), m[len(m)-1].NOutputPlane; in != out {
+		return fmt.Errorf("nInputPlane=%d of the model does not match nOutputPlane=%d", in, out)
+	}
+	if a, b := m.geometry(); a < 1 || b < 0 || b%a != 0 || (b/a)%2 != 0 {
+		return fmt.Errorf("unsupported model geometry, the output size is %d*n-%d for the input size n", a, b)
+	}
+	return nil
+}
+
+// validate checks the shape of the layer.
+func (p Param) validate() error {
+	var err error
+	switch p.Type() {
+	case Convolution:
+		err = p.validateConvolution()
+	case Deconvolution:
+		err = p.validateDeconvolution()
+	default:
+		err = fmt.Errorf("unsupported layer class %q", p.ClassName)
+	}
+	if err != nil {
+		return err
+	}
+	if len(p.Bias) != p.NOutputPlane {
+		return fmt.Errorf("len(bias)=%d, but nOutputPlane=%d", len(p.Bias), p.NOutputPlane)
+	}
+	return p.validateWeight()
+}
+
+func (p Param) validateConvolution() error {
+	if p.KW != p.KH || p.KW < 1 || p.KW%2 == 0 {
+		return fmt.Errorf("unsupported kernel size %dx%d, it must be odd and square", p.KW, p.KH)
+	}
+	if p.stride() != 1 || p.DH > 1 || p.PadW != 0 || p.PadH != 0 {
+		return fmt.Errorf("unsupported convolution, stride must be 1 and padding must be 0")
+	}
+	return nil
+}
+
+func (p Param) validateDeconvolution() error {
+	if p.KW != p.KH || p.KW < 1 || p.DW < 1 || p.DW != p.DH || p.PadW != p.PadH {
+		return fmt.Errorf("unsupported deconvolution, kernel, stride and padding must be square")
+	}
+	if p.PadW < p.KW-p.DW {
+		// the padding must crop the borders which do not receive all the kernel taps.
+		return fmt.Errorf("unsupported deconvolution, padding must be >= kW-dW")
+	}
+	return nil
+}
+
+func (p Param) validateWeight() error {
+	if p.WeightVec != nil {
+		if len(p.WeightVec) != p.NInputPlane*p.NOutputPlane*p.KW*p.KH {
+			return fmt.Errorf("len(weight)=%d does not match the layer shape", len(p.WeightVec))
+		}
+		return nil
+	}
+	n0, n1 := p.NOutputPlane, p.NInputPlane
+	if p.Type() == Deconvolution {
+		n0, n1 = n1, n0
+	}
+	if len(p.Weight) != n0 {
+		return fmt.Errorf("len(weight)=%d, but %d planes", len(p.Weight), n0)
+	}
+	for _, w := range p.Weight {
+		if len(w) != n1 {
+			return fmt.Errorf("len(weight[i])=%d, but %d planes", len(w), n1)
+		}
+		for _, k := range w {
+			if len(k) != p.KH {
+				return fmt.Errorf("the weight does not match the kernel size %dx%d", p.KW, p.KH)
+			}
+			for _, row := range k {
+				if len(row) != p.KW {
+					return fmt.Errorf("the weight does not match the kernel size %dx%d", p.KW, p.KH)
+				}
+			}
+		}
+	}
+	return nil
 }
 
 func (m Model) setWeightVec() {
 	for l := range m {
 		param := m[l]
-		// [nOutputPlane][nInputPlane][3][3]
-		const square = 9
-		vec := make([]float32, param.NInputPlane*param.NOutputPlane*9)
+		// -> [nInputPlane][nOutputPlane][kH*kW]
+		square := param.KW * param.KH
+		vec := make([]float32, param.NInputPlane*param.NOutputPlane*square)
 		for i := 0; i < param.NInputPlane; i++ {
 			for o := 0; o < param.NOutputPlane; o++ {
 				offset := i*param.NOutputPlane*square + o*square
-				vec[offset+0] = param.Weight[o][i][0][0]
-				vec[offset+1] = param.Weight[o][i][0][1]
-				vec[offset+2] = param.Weight[o][i][0][2]
-
-				vec[offset+3] = param.Weight[o][i][1][0]
-				vec[offset+4] = param.Weight[o][i][1][1]
-				vec[offset+5] = param.Weight[o][i][1][2]
-
-				vec[offset+6] = param.Weight[o][i][2][0]
-				vec[offset+7] = param.Weight[o][i][2][1]
-				vec[offset+8] = param.Weight[o][i][2][2]
+				k := param.kernel(i, o)
+				for y := 0; y < param.KH; y++ {
+					copy(vec[offset+y*param.KW:offset+(y+1)*param.KW], k[y])
+				}
 			}
 		}
 		m[l].WeightVec = vec
+		m[l].prepared = &preparedWeights{}
 	}
 }
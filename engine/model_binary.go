@@ -0,0 +1,234 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// The binary model format is the following sequence of little-endian values.
+//
+//	magic      [4]byte  "W2XM"
+//	version    uint16   binaryModelVersion
+//	layers     uint16   number of layers
+//	layer      [layers]binaryLayerHeader
+//	payload    [layers]{bias [nOutputPlane]float32, weight [nInputPlane][nOutputPlane][kH*kW]float32}
+//	checksum   uint32   CRC-32 (IEEE) of all the preceding bytes
+//
+// The weight is stored in the layout of Param.WeightVec, so the model is ready to use without Param.Weight.
+const (
+	binaryModelMagic   = "W2XM"
+	binaryModelVersion = 1
+)
+
+// Activation is the type of the activation function of a layer.
+type Activation uint8
+
+const (
+	// LeakyReLU is the leaky ReLU with the slope 0.1, which all the layers use.
+	LeakyReLU Activation = iota + 1
+)
+
+type binaryLayerHeader struct {
+	Type         uint8
+	Activation   uint8
+	KW           uint16
+	KH           uint16
+	DW           uint16
+	DH           uint16
+	PadW         uint16
+	PadH         uint16
+	NInputPlane  uint32
+	NOutputPlane uint32
+}
+
+// upper limits to reject broken headers before allocating the payload.
+// The largest layer of the waifu2x models has about 300K weights, and the largest model has about 2M.
+const (
+	binaryMaxKernel       = 64
+	binaryMaxPlanes       = 1 << 16
+	binaryMaxLayerWeights = 1 << 24
+	binaryMaxModelWeights = 1 << 26
+)
+
+// binaryReadChunk is the number of values read at once, so that a truncated payload fails
+// before the slice of the declared size is allocated.
+const binaryReadChunk = 1 << 16
+
+// WriteModelBinary writes the model in the binary model format.
+func WriteModelBinary(w io.Writer, m Model) error {
+	if len(m) > 0xffff {
+		return fmt.Errorf("too many layers: %d", len(m))
+	}
+	crc := crc32.NewIEEE()
+	bw := bufio.NewWriter(w)
+	mw := io.MultiWriter(bw, crc)
+	if _, err := io.WriteString(mw, binaryModelMagic); err != nil {
+		return err
+	}
+	if err := binary.Write(mw, binary.LittleEndian, [2]uint16{binaryModelVersion, uint16(len(m))}); err != nil {
+		return err
+	}
+	for l, p := range m {
+		if len(p.WeightVec) != p.NInputPlane*p.NOutputPlane*p.KW*p.KH || len(p.Bias) != p.NOutputPlane {
+			return fmt.Errorf("layer %d: weight vector is not prepared", l)
+		}
+		h := binaryLayerHeader{
+			Type:         uint8(p.Type()),
+			Activation:   uint8(LeakyReLU),
+			KW:           uint16(p.KW),
+			KH:           uint16(p.KH),
+			DW:           uint16(p.DW),
+			DH:           uint16(p.DH),
+			PadW:         uint16(p.PadW),
+			PadH:         uint16(p.PadH),
+			NInputPlane:  uint32(p.NInputPlane),
+			NOutputPlane: uint32(p.NOutputPlane),
+		}
+		if err := binary.Write(mw, binary.LittleEndian, h); err != nil {
+			return err
+		}
+	}
+	for _, p := range m {
+		if err := binary.Write(mw, binary.LittleEndian, p.Bias); err != nil {
+			return err
+		}
+		if err := binary.Write(mw, binary.LittleEndian, p.WeightVec); err != nil {
+			return err
+		}
+	}
+	if err := binary.Write(bw, binary.LittleEndian, crc.Sum32()); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// LoadModelBinary loads a trained model in the binary model format from the io.Reader.
+func LoadModelBinary(r io.Reader) (Model, error) {
+	crc := crc32.NewIEEE()
+	br := bufio.NewReader(r)
+	tr := io.TeeReader(br, crc)
+	var magic [4]byte
+	if _, err := io.ReadFull(tr, magic[:]); err != nil {
+		return nil, fmt.Errorf("invalid binary model: %w", err)
+	}
+	if string(magic[:]) != binaryModelMagic {
+		return nil, fmt.Errorf("invalid binary model: magic %q", magic[:])
+	}
+	var vl [2]uint16
+	if err := binary.Read(tr, binary.LittleEndian, &vl); err != nil {
+		return nil, fmt.Errorf("invalid binary model: %w", err)
+	}
+	if vl[0] != binaryModelVersion {
+		return nil, fmt.Errorf("unsupported binary model version: %d", vl[0])
+	}
+	headers := make([]binaryLayerHeader, vl[1])
+	if err := binary.Read(tr, binary.LittleEndian, headers); err != nil {
+		return nil, fmt.Errorf("invalid binary model: %w", err)
+	}
+	m := make(Model, len(headers))
+	var total uint64
+	for l, h := range headers {
+		p, err := h.param()
+		if err != nil {
+			return nil, fmt.Errorf("layer %d: %w", l, err)
+		}
+		n := uint64(h.NInputPlane) * uint64(h.NOutputPlane) * uint64(h.KW) * uint64(h.KH)
+		if total += n + uint64(h.NOutputPlane); n > binaryMaxLayerWeights || total > binaryMaxModelWeights {
+			return nil, fmt.Errorf("layer %d: too many weights", l)
+		}
+		m[l] = p
+	}
+	if err := m.readPayload(tr); err != nil {
+		return nil, fmt.Errorf("invalid binary model: %w", err)
+	}
+	sum := crc.Sum32()
+	var want uint32
+	if err := binary.Read(br, binary.LittleEndian, &want); err != nil {
+		return nil, fmt.Errorf("invalid binary model: %w", err)
+	}
+	if sum != want {
+		return nil, fmt.Errorf("invalid binary model: checksum mismatch")
+	}
+	if err := m.validate(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// param returns the layer of the header without the weights.
+func (h binaryLayerHeader) param() (Param, error) {
+	if h.Activation != uint8(LeakyReLU) {
+		return Param{}, fmt.Errorf("unsupported activation type %d", h.Activation)
+	}
+	if h.KW > binaryMaxKernel || h.KH > binaryMaxKernel || h.NInputPlane > binaryMaxPlanes || h.NOutputPlane > binaryMaxPlanes {
+		return Param{}, fmt.Errorf("invalid layer shape")
+	}
+	p := Param{
+		KW:           int(h.KW),
+		KH:           int(h.KH),
+		DW:           int(h.DW),
+		DH:           int(h.DH),
+		PadW:         int(h.PadW),
+		PadH:         int(h.PadH),
+		NInputPlane:  int(h.NInputPlane),
+		NOutputPlane: int(h.NOutputPlane),
+	}
+	switch LayerType(h.Type) {
+	case Convolution:
+		p.ClassName = convolutionClassName
+	case Deconvolution:
+		p.ClassName = deconvolutionClassName
+	default:
+		return Param{}, fmt.Errorf("unsupported layer type %d", h.Type)
+	}
+	return p, nil
+}
+
+// readPayload reads the biases and the weights of the layers.
+func (m Model) readPayload(r io.Reader) error {
+	for l, p := range m {
+		var err error
+		if m[l].Bias, err = readFloat32s(r, p.NOutputPlane); err != nil {
+			return err
+		}
+		if m[l].WeightVec, err = readFloat32s(r, p.NInputPlane*p.NOutputPlane*p.KW*p.KH); err != nil {
+			return err
+		}
+		m[l].prepared = &preparedWeights{}
+	}
+	return nil
+}
+
+// readFloat32s reads the n values in chunks of binaryReadChunk.
+func readFloat32s(r io.Reader, n int) ([]float32, error) {
+	size := n
+	if size > binaryReadChunk {
+		size = binaryReadChunk
+	}
+	ret := make([]float32, 0, size)
+	chunk := make([]float32, size)
+	for len(ret) < n {
+		c := chunk
+		if rest := n - len(ret); rest < len(c) {
+			c = c[:rest]
+		}
+		if err := binary.Read(r, binary.LittleEndian, c); err != nil {
+			return nil, err
+		}
+		ret = append(ret, c...)
+	}
+	return ret, nil
+}
+
+// loadModelAuto loads a trained model in the binary model format or in JSON.
+func loadModelAuto(r io.Reader) (Model, error) {
+	br := bufio.NewReader(r)
+	if b, err := br.Peek(len(binaryModelMagic)); err == nil && bytes.Equal(b, []byte(binaryModelMagic)) {
+		return LoadModelBinary(br)
+	}
+	return LoadModel(br)
+}
@@ -0,0 +1,120 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/fs"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestWriteModelBinary(t *testing.T) {
+	for _, m := range []Model{
+		randomModel(1, []int{3, 8, 8, 3}, []int{5, 1, 3}),
+		randomUpconvModel(1),
+	} {
+		var b bytes.Buffer
+		if err := WriteModelBinary(&b, m); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		data := b.Bytes()
+		got, err := LoadModelBinary(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for l := range m {
+			want := m[l]
+			want.Weight = nil
+			if want.ClassName == "" {
+				want.ClassName = convolutionClassName
+			}
+			if !reflect.DeepEqual(want, got[l]) {
+				t.Errorf("layer %d: want %+v, got %+v", l, want, got[l])
+			}
+		}
+		if got.Overlap() != m.Overlap() || got.Scale() != m.Scale() {
+			t.Errorf("geometry differs")
+		}
+
+		// the format is detected automatically
+		fsys := fstest.MapFS{
+			"m/scale2.0x_model.bin": &fstest.MapFile{Data: data},
+		}
+		set, err := LoadModelSet(fsys, "m", 0)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(got, set.Scale2xModel) {
+			t.Errorf("model loaded from the model set differs")
+		}
+
+		broken := append([]byte{}, data...)
+		broken[len(broken)/2] ^= 0xff
+		if _, err := LoadModelBinary(bytes.NewReader(broken)); err == nil {
+			t.Errorf("expected checksum error")
+		}
+		if _, err := LoadModelBinary(bytes.NewReader(data[:len(data)-1])); err == nil {
+			t.Errorf("expected error for a truncated model")
+		}
+	}
+}
+
+func TestLoadModelBinary_Limits(t *testing.T) {
+	header := func(h binaryLayerHeader) []byte {
+		var b bytes.Buffer
+		b.WriteString(binaryModelMagic)
+		_ = binary.Write(&b, binary.LittleEndian, [2]uint16{binaryModelVersion, 1})
+		_ = binary.Write(&b, binary.LittleEndian, h)
+		return b.Bytes()
+	}
+	tests := []struct {
+		name string
+		h    binaryLayerHeader
+	}{
+		{name: "too many weights", h: binaryLayerHeader{KW: 63, KH: 63, NInputPlane: 60000, NOutputPlane: 60000}},
+		{name: "truncated payload", h: binaryLayerHeader{KW: 3, KH: 3, NInputPlane: 1024, NOutputPlane: 1024}},
+	}
+	for _, tt := range tests {
+		tt.h.Type, tt.h.Activation = uint8(Convolution), uint8(LeakyReLU)
+		if _, err := LoadModelBinary(bytes.NewReader(header(tt.h))); err == nil {
+			t.Errorf("%s: expected error", tt.name)
+		}
+	}
+}
+
+func TestModelAssets_Binary(t *testing.T) {
+	files, err := fs.Glob(assets, "model/*/*.json")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no JSON models in the assets, %v", err)
+	}
+	for _, f := range files {
+		want, err := LoadModelAssets(f)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		got, err := LoadModelAssets(strings.TrimSuffix(f, ".json") + ".bin")
+		if err != nil {
+			t.Fatalf("%s: the binary model is not generated, run go generate, %v", f, err)
+		}
+		for l := range want {
+			want[l].Weight = nil
+			want[l].ClassName = got[l].ClassName
+			if !reflect.DeepEqual(want[l], got[l]) {
+				t.Errorf("%s: layer %d: the binary model differs from the JSON one", f, l)
+			}
+		}
+	}
+
+	// the binary models are loaded by default
+	if _, err := NewAssetModelSet(Photo, 3); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := DefaultModelCache.Load("assets:"+photoModelDir+"/noise3_model.bin", func() (Model, error) {
+		return nil, fmt.Errorf("the binary model is not cached")
+	}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,86 @@
+package engine
+
+import (
+	"fmt"
+	"sync"
+)
+
+// ModelCache is a concurrency-safe cache of trained models keyed by the identity of a model, e.g. its path.
+// The cached models are shared by all the users, so they must be treated as read-only.
+type ModelCache struct {
+	mu      sync.Mutex
+	entries map[string]*modelCacheEntry
+}
+
+type modelCacheEntry struct {
+	ready chan struct{} // closed when the model has been loaded
+	model Model
+	err   error
+}
+
+// DefaultModelCache is the process-wide model cache, which NewAssetModelSet and NewWaifu2x use.
+var DefaultModelCache = NewModelCache()
+
+// NewModelCache returns an empty model cache.
+func NewModelCache() *ModelCache {
+	return &ModelCache{
+		entries: map[string]*modelCacheEntry{},
+	}
+}
+
+// Load returns the cached model of the key. If the model is not cached, Load calls the load function
+// to load it lazily. Concurrent calls with the same key wait for a single call of the load function.
+// The error of the load function is not cached, so the next call will try to load the model again.
+func (c *ModelCache) Load(key string, load func() (Model, error)) (Model, error) {
+	c.mu.Lock()
+	e, ok := c.entries[key]
+	if !ok {
+		e = &modelCacheEntry{ready: make(chan struct{})}
+		c.entries[key] = e
+	}
+	c.mu.Unlock()
+	if ok {
+		<-e.ready
+		return e.model, e.err
+	}
+
+	defer close(e.ready)
+	defer func() {
+		if e.err != nil {
+			c.mu.Lock()
+			if c.entries[key] == e {
+				delete(c.entries, key)
+			}
+			c.mu.Unlock()
+		}
+	}()
+	e.err = fmt.Errorf("failed to load the model %s", key) // for the waiters when the load function panics
+	e.model, e.err = load()
+	return e.model, e.err
+}
+
+// Evict removes the model of the key from the cache.
+// The users of the model can keep using it, it is released when they drop it.
+func (c *ModelCache) Evict(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, key)
+}
+
+// EvictAll removes all the models from the cache.
+func (c *ModelCache) EvictAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = map[string]*modelCacheEntry{}
+}
+
+// Keys returns the keys of the cached models, including the ones being loaded.
+func (c *ModelCache) Keys() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ret := make([]string, 0, len(c.entries))
+	for k := range c.entries {
+		ret = append(ret, k)
+	}
+	return ret
+}
@@ -0,0 +1,102 @@
+package engine
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestModelCache(t *testing.T) {
+	c := NewModelCache()
+	var calls int32
+	load := func() (Model, error) {
+		atomic.AddInt32(&calls, 1)
+		return randomModel(1, []int{1, 1}, []int{3}), nil
+	}
+	var wg sync.WaitGroup
+	models := make([]Model, 8)
+	for i := range models {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			m, err := c.Load("a", load)
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			models[i] = m
+		}(i)
+	}
+	wg.Wait()
+	if calls != 1 {
+		t.Errorf("want 1 call of the loader, got %d", calls)
+	}
+	for _, m := range models {
+		if &m[0] != &models[0][0] {
+			t.Errorf("expected the shared model")
+		}
+	}
+
+	c.Evict("a")
+	if _, err := c.Load("a", load); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("want 2 calls of the loader after eviction, got %d", calls)
+	}
+
+	if _, err := c.Load("b", func() (Model, error) { return nil, errors.New("error") }); err == nil {
+		t.Errorf("expected error")
+	}
+	if want, got := 1, len(c.Keys()); want != got {
+		t.Errorf("the error must not be cached, want %d keys, got %d", want, got)
+	}
+	c.EvictAll()
+	if want, got := 0, len(c.Keys()); want != got {
+		t.Errorf("want %d keys, got %d", want, got)
+	}
+}
+
+func TestNewAssetModelSet_Cache(t *testing.T) {
+	a, err := NewAssetModelSet(Photo, 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	b, err := NewAssetModelSet(Photo, 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if &a.Scale2xModel[0] != &b.Scale2xModel[0] || &a.NoiseModel[0] != &b.NoiseModel[0] {
+		t.Errorf("expected the models shared through the cache")
+	}
+}
+
+func TestNewWaifu2x_SharedWeights(t *testing.T) {
+	tests := []struct {
+		name    string
+		backend Backend
+		weights func(p Param) []float32
+	}{
+		{name: "Direct", backend: Direct, weights: func(p Param) []float32 { return p.weightFMA }},
+		{name: "GEMM", backend: GEMM, weights: func(p Param) []float32 { return p.weightGEMM }},
+		{name: "Winograd", backend: Winograd, weights: func(p Param) []float32 { return p.weightWinograd }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := NewWaifu2x(Photo, 1, ConvolutionBackend(tt.backend))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			b, err := NewWaifu2x(Photo, 1, ConvolutionBackend(tt.backend))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for l := range a.noiseModel {
+				wa, wb := tt.weights(a.noiseModel[l]), tt.weights(b.noiseModel[l])
+				if len(wa) > 0 && &wa[0] != &wb[0] {
+					t.Errorf("layer %d: expected the weights prepared once", l)
+				}
+			}
+		})
+	}
+}
@@ -1,7 +1,9 @@
 package engine
 
 import (
+	"os"
 	"testing"
+	"testing/fstest"
 )
 
 func TestLoadModel(t *testing.T) {
@@ -27,6 +29,43 @@ func TestLoadModel(t *testing.T) {
 	}
 }
 
+func TestLoadModelSet(t *testing.T) {
+	m, err := LoadModelSet(os.DirFS("./model"), "photo", 2)
+	if err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if m.Scale2xModel == nil || m.NoiseModel == nil {
+		t.Errorf("expected both scale and noise models, got %+v", m)
+	}
+	if _, err := NewWaifu2xModelSet(m); err != nil {
+		t.Errorf("unexpected error, %v", err)
+	}
+	fsys := fstest.MapFS{
+		"broken/scale2.0x_model.json": &fstest.MapFile{
+			Data: []byte(`[{"bias":[0],"kW":3,"kH":3,"weight":[[[[0,0,0],[0,0,0]]]],"nInputPlane":1,"nOutputPlane":1}]`),
+		},
+	}
+	if _, err := LoadModelSet(fsys, "broken", 0); err == nil {
+		t.Errorf("expected error for a model whose weight does not match the kernel size")
+	}
+	if _, err := LoadModelSet(fsys, "broken", 1); err == nil {
+		t.Errorf("expected error for a missing noise model")
+	}
+}
+
+func TestLoadNoiseModelSetDir(t *testing.T) {
+	m, err := LoadNoiseModelSetDir("./model/photo", 2)
+	if err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if m.Scale2xModel != nil || m.NoiseModel == nil {
+		t.Errorf("expected the noise model only")
+	}
+	if _, err := LoadNoiseModelSetDir("./model/photo", 0); err == nil {
+		t.Errorf("expected error for the noise level 0")
+	}
+}
+
 func Test_setWeightVec(t *testing.T) {
 	model, err := LoadModelFile("./model/anime_style_art/scale2.0x_model.json")
 	if err != nil {
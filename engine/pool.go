@@ -0,0 +1,64 @@
+package engine
+
+import (
+	"context"
+	"sync"
+)
+
+// workerPool is the fixed number of workers which process the blocks pulled from the queue.
+// A pool is shared by the phases, the passes and the frames of a job, and each worker reuses its scratch buffers.
+type workerPool struct {
+	queue   chan task
+	wg      sync.WaitGroup
+	workers int
+}
+
+// task is a block of a phase.
+type task struct {
+	index int
+	f     func(i int, s *scratch)
+	wg    *sync.WaitGroup
+}
+
+// newWorkerPool starts the workers, n < 1 means a worker.
+func newWorkerPool(n int) *workerPool {
+	if n < 1 {
+		n = 1
+	}
+	p := &workerPool{queue: make(chan task), workers: n}
+	p.wg.Add(n)
+	for i := 0; i < n; i++ {
+		go p.work()
+	}
+	return p
+}
+
+func (p *workerPool) work() {
+	defer p.wg.Done()
+	s := &scratch{}
+	for t := range p.queue {
+		t.f(t.index, s)
+		t.wg.Done()
+	}
+}
+
+// run calls f for the blocks from 0 to n-1 on the workers, and waits for them.
+// It stops queueing the blocks as soon as the context is done.
+func (p *workerPool) run(ctx context.Context, n int, f func(i int, s *scratch)) {
+	var wg sync.WaitGroup
+	for i := 0; i < n && ctx.Err() == nil; i++ {
+		wg.Add(1)
+		select {
+		case p.queue <- task{index: i, f: f, wg: &wg}:
+		case <-ctx.Done():
+			wg.Done() // the blocks already queued are drained below
+		}
+	}
+	wg.Wait()
+}
+
+// close stops the workers after the queued blocks are processed.
+func (p *workerPool) close() {
+	close(p.queue)
+	p.wg.Wait()
+}
@@ -0,0 +1,49 @@
+package engine
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+func Test_workerPool(t *testing.T) {
+	p := newWorkerPool(3)
+	defer p.close()
+
+	var mu sync.Mutex
+	scratches := map[*scratch]bool{}
+	done := make([]bool, 100)
+	for phase := 0; phase < 2; phase++ { // the pool is reused by the phases
+		p.run(context.TODO(), len(done), func(i int, s *scratch) {
+			mu.Lock()
+			defer mu.Unlock()
+			scratches[s] = true
+			done[i] = true
+		})
+	}
+	for i, v := range done {
+		if !v {
+			t.Errorf("block %d is not processed", i)
+		}
+	}
+	if len(scratches) > 3 {
+		t.Errorf("want at most 3 workers, got %d scratches", len(scratches))
+	}
+}
+
+func Test_workerPool_Cancel(t *testing.T) {
+	p := newWorkerPool(1)
+	defer p.close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var n int
+	p.run(ctx, 100, func(i int, s *scratch) {
+		n++
+		if i == 10 {
+			cancel()
+		}
+	})
+	if n > 12 {
+		t.Errorf("want to stop queueing the blocks after the cancel, but %d blocks are processed", n)
+	}
+}
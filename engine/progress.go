@@ -0,0 +1,195 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+	"time"
+)
+
+// Phase represents a processing phase of waifu2x.
+type Phase int
+
+const (
+	// PhaseDecompose is the phase of decomposing an image to channels.
+	PhaseDecompose Phase = iota + 1
+	// PhaseDenoise is the phase of applying the noise reduction model.
+	PhaseDenoise
+	// PhaseScale is the phase of applying the scale model.
+	PhaseScale
+	// PhaseCompose is the phase of composing channels to an image.
+	PhaseCompose
+	// PhaseScaleAlpha is the phase of applying the scale model to the alpha channel.
+	PhaseScaleAlpha
+)
+
+// String returns string representation of a phase.
+func (p Phase) String() string {
+	switch p {
+	case PhaseDecompose:
+		return "decompose"
+	case PhaseDenoise:
+		return "de-noise"
+	case PhaseScale:
+		return "scale"
+	case PhaseCompose:
+		return "compose"
+	case PhaseScaleAlpha:
+		return "scale alpha"
+	}
+	return fmt.Sprintf("unknown phase=%d", p)
+}
+
+// Progress represents a progress event of a job.
+type Progress struct {
+	Phase       Phase         // current phase
+	Pass        int           // 1-origin index of the current scale pass
+	Passes      int           // number of scale passes of the job
+	BlocksDone  int           // number of blocks processed in the current phase
+	BlocksTotal int           // number of blocks of the current phase, 0 if the phase is not block based
+	Frame       int           // 0-origin index of the current GIF frame
+	Frames      int           // number of GIF frames, 1 for a still image
+	Band        int           // 0-origin index of the current band of a stream scale up
+	Bands       int           // number of bands, 1 unless stream scale up
+	Workers     int           // number of the parallel workers of the job
+	Elapsed     time.Duration // elapsed time since the job started
+}
+
+// Fraction returns the approximate completion ratio of the whole job in [0, 1].
+func (p Progress) Fraction() float64 {
+	if p.Passes == 0 || p.Frames == 0 || p.Bands == 0 {
+		return 0
+	}
+	var phase float64
+	switch p.Phase {
+	case PhaseCompose:
+		phase = 1
+	case PhaseDenoise, PhaseScale, PhaseScaleAlpha:
+		if p.BlocksTotal > 0 {
+			phase = float64(p.BlocksDone) / float64(p.BlocksTotal)
+		}
+	}
+	pass := (float64(p.Pass-1) + phase) / float64(p.Passes)
+	band := (float64(p.Band) + pass) / float64(p.Bands)
+	return math.Min((float64(p.Frame)+band)/float64(p.Frames), 1)
+}
+
+// ProgressFunc is a function that receives progress events.
+// Calls for a job are serialized, so the function does not have to be goroutine safe.
+type ProgressFunc func(p Progress)
+
+// TerminalProgress returns a ProgressFunc that prints the progress to the terminal.
+func TerminalProgress(w io.Writer) ProgressFunc {
+	return func(p Progress) {
+		if p.BlocksTotal == 0 || p.BlocksDone == 0 {
+			switch p.Phase {
+			case PhaseDecompose:
+				fmt.Fprintf(w, "# of goroutines: %d\n", p.Workers)
+				fmt.Fprintln(w, "decomposing channels ...")
+			case PhaseDenoise:
+				fmt.Fprintln(w, "de-noising ...")
+			case PhaseScale:
+				fmt.Fprintln(w, "scaling ...")
+			case PhaseCompose:
+				fmt.Fprintln(w, "composing channels ...")
+			case PhaseScaleAlpha:
+				fmt.Fprintln(w, "scaling alpha ...")
+			}
+		}
+		if p.BlocksTotal == 0 {
+			return
+		}
+		digits := int(math.Log10(float64(p.BlocksTotal))) + 2
+		fmt.Fprintf(w, "\x1b[2K\r%*d/%*d (%.1f%%)", digits, p.BlocksDone, digits, p.BlocksTotal, float64(p.BlocksDone)/float64(p.BlocksTotal)*100)
+		if p.BlocksDone == p.BlocksTotal {
+			fmt.Fprintln(w)
+		}
+	}
+}
+
+// job holds the state of a scale up request.
+type job struct {
+	mu        sync.Mutex
+	observers []ProgressFunc
+	start     time.Time
+	frame     int
+	frames    int
+	band      int
+	bands     int
+	pass      int
+	passes    int
+	phase     Phase
+	done      int
+	total     int
+	pool      *workerPool
+}
+
+// newJob starts the job with the pool of the parallel workers, which must be closed by close.
+func newJob(observers []ProgressFunc, parallel int) *job {
+	return &job{
+		observers: observers,
+		start:     time.Now(),
+		frames:    1,
+		bands:     1,
+		pool:      newWorkerPool(parallel),
+	}
+}
+
+// run processes the blocks of the current phase on the workers of the job.
+func (j *job) run(ctx context.Context, blocks int, f func(i int, s *scratch)) {
+	if j == nil {
+		j = newJob(nil, 1)
+		defer j.close()
+	}
+	j.pool.run(ctx, blocks, f)
+}
+
+// close stops the workers of the job.
+func (j *job) close() {
+	if j != nil {
+		j.pool.close()
+	}
+}
+
+// startPhase reports the beginning of the phase which consists of the specified number of blocks.
+func (j *job) startPhase(phase Phase, blocks int) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.phase, j.done, j.total = phase, 0, blocks
+	j.notify()
+}
+
+// blockDone reports that a block of the current phase has been processed.
+func (j *job) blockDone() {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.done++
+	j.notify()
+}
+
+func (j *job) notify() {
+	p := Progress{
+		Phase:       j.phase,
+		Pass:        j.pass,
+		Passes:      j.passes,
+		BlocksDone:  j.done,
+		BlocksTotal: j.total,
+		Frame:       j.frame,
+		Frames:      j.frames,
+		Band:        j.band,
+		Bands:       j.bands,
+		Workers:     j.pool.workers,
+		Elapsed:     time.Since(j.start),
+	}
+	for _, f := range j.observers {
+		f(p)
+	}
+}
@@ -0,0 +1,107 @@
+package engine
+
+import (
+	"image"
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func Test_convolutionInt8(t *testing.T) {
+	testdata := []struct {
+		name          string
+		planes        []int
+		width, height int
+	}{
+		{name: "odd rows", planes: []int{3, 8}, width: 17, height: 13},
+		{name: "panels", planes: []int{5, 12}, width: 40, height: 30}, // 1064 output pixels
+	}
+	for _, tt := range testdata {
+		t.Run(tt.name, func(t *testing.T) {
+			m := randomModel(1, tt.planes, []int{3})
+			in := randomPlanes(2, tt.planes[0], tt.width, tt.height)
+			want := convolution(in, m[0].WeightVec, m[0].NOutputPlane, m[0].Bias, nil)
+			q := quantizeWeights(m[0].WeightVec, m[0].NOutputPlane, 1)
+			got := convolutionInt8(in, q, m[0].NOutputPlane, m[0].Bias, nil)
+			assertPlanesNear(t, want, got, 0.05)
+		})
+	}
+}
+
+func Test_gemmAccumulateInt16(t *testing.T) {
+	// 7 rows = 4 + 3, 45 columns = 16 + 16 + 8 + 5 in the matrices of wider rows
+	const m, k, n, lda, ldb, ldc = 7, 13, 45, 30, 100, 50
+	r := rand.New(rand.NewSource(1))
+	a := make([]int16, m*lda)
+	b := make([]int16, k*ldb)
+	for i := range a {
+		a[i] = int16(r.Intn(255) - 127)
+	}
+	for i := range b {
+		b[i] = int16(r.Intn(255) - 127)
+	}
+	want := make([]int32, m*ldc)
+	for i := range want {
+		want[i] = int32(r.Intn(1000))
+	}
+	got := append([]int32(nil), want...)
+	for i := 0; i < m; i++ {
+		for p := 0; p < k; p++ {
+			for j := 0; j < n; j++ {
+				want[i*ldc+j] += int32(a[i*lda+2*p])*int32(b[p*ldb+2*j]) + int32(a[i*lda+2*p+1])*int32(b[p*ldb+2*j+1])
+			}
+		}
+	}
+	gemmAccumulateInt16(got, ldc, a, lda, b, ldb, m, k, n)
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+}
+
+func TestQuantizeModel(t *testing.T) {
+	m := randomModel(1, []int{3, 8, 8, 3}, []int{3, 5, 3})
+	img := loadTestImage(t, "../testdata/neko_small.png", 24, 24)
+	q, err := QuantizeModel(m, []image.Image{img})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for l, want := range []bool{true, false, true} {
+		if got := q[l].weightInt8 != nil; want != got {
+			t.Errorf("layer %d: want quantized %v, got %v", l, want, got)
+		}
+		if m[l].weightInt8 != nil {
+			t.Errorf("layer %d: the model must not be modified", l)
+		}
+	}
+	if _, err := QuantizeModel(m, nil); err == nil {
+		t.Errorf("expected error for no calibration samples")
+	}
+}
+
+func TestPSNR(t *testing.T) {
+	a := NewChannelImageWidthHeight(2, 2)
+	b := NewChannelImageWidthHeight(2, 2)
+	if got, err := PSNR(a, b); err != nil || !math.IsInf(got, 1) {
+		t.Errorf("want +Inf, got %v, %v", got, err)
+	}
+	for i := range b.Buffer {
+		b.Buffer[i] = 255
+	}
+	if got, err := PSNR(a, b); err != nil || got != 0 {
+		t.Errorf("want 0, got %v, %v", got, err)
+	}
+	// the alpha channel is not counted
+	a = ChannelImage{Width: 2, Height: 1, Buffer: []uint8{10, 20, 30, 255, 40, 50, 60, 255}}
+	b = ChannelImage{Width: 2, Height: 1, Buffer: []uint8{10, 20, 30, 0, 40, 50, 60, 128}}
+	if got, err := PSNR(a, b); err != nil || !math.IsInf(got, 1) {
+		t.Errorf("want +Inf, got %v, %v", got, err)
+	}
+	b.Buffer[0] = 0
+	if got, err := PSNR(a, b); err != nil || math.Abs(got-10*math.Log10(255*255*6/100.)) > 1e-9 {
+		t.Errorf("want the PSNR over RGB, got %v, %v", got, err)
+	}
+	if _, err := PSNR(a, NewChannelImageWidthHeight(3, 2)); err == nil {
+		t.Errorf("expected error for images of different sizes")
+	}
+}
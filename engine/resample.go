@@ -0,0 +1,130 @@
+package engine
+
+import "math"
+
+// catmullRom is the bicubic convolution kernel with a = -0.5.
+func catmullRom(x float64) float64 {
+	x = math.Abs(x)
+	switch {
+	case x < 1:
+		return (1.5*x-2.5)*x*x + 1
+	case x < 2:
+		return ((-0.5*x+2.5)*x-4)*x + 2
+	}
+	return 0
+}
+
+// lanczos3 is the Lanczos kernel with a = 3.
+func lanczos3(x float64) float64 {
+	x = math.Abs(x)
+	switch {
+	case x == 0:
+		return 1
+	case x < 3:
+		px := math.Pi * x
+		return 3 * math.Sin(px) * math.Sin(px/3) / (px * px)
+	}
+	return 0
+}
+
+// resampleWeights returns the source indices and the weights of the filter for each destination pixel.
+// The indices are clamped to the source size, i.e. the edge pixels are extended.
+func resampleWeights(src, dst int, kernel func(float64) float64, support float64) ([][]int, [][]float32) {
+	scale := float64(src) / float64(dst)
+	filterScale := math.Max(scale, 1) // widen the kernel when downsampling
+	radius := support * filterScale
+	indices := make([][]int, dst)
+	weights := make([][]float32, dst)
+	for d := 0; d < dst; d++ {
+		center := (float64(d)+0.5)*scale - 0.5
+		lo := int(math.Ceil(center - radius))
+		hi := int(math.Floor(center + radius))
+		var sum float64
+		ws := make([]float64, 0, hi-lo+1)
+		for s := lo; s <= hi; s++ {
+			v := kernel((float64(s) - center) / filterScale)
+			ws = append(ws, v)
+			sum += v
+		}
+		for k, s := 0, lo; s <= hi; k, s = k+1, s+1 {
+			if ws[k] == 0 {
+				continue
+			}
+			i := s
+			if i < 0 {
+				i = 0
+			} else if i >= src {
+				i = src - 1
+			}
+			indices[d] = append(indices[d], i)
+			weights[d] = append(weights[d], float32(ws[k]/sum))
+		}
+	}
+	return indices, weights
+}
+
+// resample returns the image plane resampled to the specified size with the separable filter.
+func (p ImagePlane) resample(width, height int, kernel func(float64) float64, support float64) ImagePlane {
+	if width == p.Width && height == p.Height {
+		return p
+	}
+	// horizontal
+	xi, xw := resampleWeights(p.Width, width, kernel, support)
+	tmp := NewImagePlaneWidthHeight(width, p.Height)
+	for y := 0; y < p.Height; y++ {
+		row := p.Buffer[y*p.Width : (y+1)*p.Width]
+		for x := 0; x < width; x++ {
+			var v float32
+			for k, i := range xi[x] {
+				v += row[i] * xw[x][k]
+			}
+			tmp.Buffer[x+y*width] = v
+		}
+	}
+	// vertical
+	yi, yw := resampleWeights(p.Height, height, kernel, support)
+	ret := NewImagePlaneWidthHeight(width, height)
+	for y := 0; y < height; y++ {
+		for k, i := range yi[y] {
+			wt := yw[y][k]
+			src := tmp.Buffer[i*width : (i+1)*width]
+			dst := ret.Buffer[y*width : (y+1)*width]
+			for x := range dst {
+				dst[x] += src[x] * wt
+			}
+		}
+	}
+	return ret
+}
+
+// ResizeBicubic returns the image plane resized to the specified size with the bicubic filter.
+func (p ImagePlane) ResizeBicubic(width, height int) ImagePlane {
+	return p.resample(width, height, catmullRom, 2)
+}
+
+// ResizeBicubic returns the channel image resized to the specified size with the bicubic filter.
+func (c ChannelImage) ResizeBicubic(width, height int) ChannelImage {
+	return c.resample(width, height, catmullRom, 2)
+}
+
+// ResizeLanczos returns the image plane resized to the specified size with the Lanczos filter.
+func (p ImagePlane) ResizeLanczos(width, height int) ImagePlane {
+	return p.resample(width, height, lanczos3, 3)
+}
+
+// ResizeLanczos returns the channel image resized to the specified size with the Lanczos filter.
+func (c ChannelImage) ResizeLanczos(width, height int) ChannelImage {
+	return c.resample(width, height, lanczos3, 3)
+}
+
+// resample returns the channel image resampled to the specified size with the separable filter.
+func (c ChannelImage) resample(width, height int, kernel func(float64) float64, support float64) ChannelImage {
+	if width == c.Width && height == c.Height {
+		return c
+	}
+	p := NewImagePlaneWidthHeight(c.Width, c.Height)
+	for i := range p.Buffer {
+		p.Buffer[i] = float32(c.Buffer[i]) / 255.0
+	}
+	return NewDenormalizedChannelImage(p.resample(width, height, kernel, support))
+}
@@ -0,0 +1,166 @@
+package engine
+
+// scratch is the buffers which a worker reuses for the layers of the blocks, so that the layers do not allocate.
+// The methods of a nil scratch allocate new buffers, e.g. for the kernels called directly.
+type scratch struct {
+	input   []float32    // the input block
+	buffers [2][]float32 // ping-pong buffers of the output planes of the layers
+	planes  [3][]ImagePlane
+	next    int
+	temps   [4][]float32 // temporary buffers of the kernels, the last one is for the weights, see Param.weights
+	int16s  [2][]int16
+	int32s  []int32
+}
+
+// grow returns the buffer of the size, which reuses the given one if it is large enough.
+func grow(buf []float32, size int) []float32 {
+	if cap(buf) < size {
+		return make([]float32, size)
+	}
+	return buf[:size]
+}
+
+// newPlanes returns the planes backed by the buffer, reusing the given slice of the planes.
+func newPlanes(planes []ImagePlane, buf []float32, n, width, height int) []ImagePlane {
+	if cap(planes) < n {
+		planes = make([]ImagePlane, n)
+	}
+	planes = planes[:n]
+	size := width * height
+	for i := range planes {
+		planes[i] = ImagePlane{Width: width, Height: height, Buffer: buf[i*size : (i+1)*size : (i+1)*size]}
+	}
+	return planes
+}
+
+// inputPlanes returns the planes for the input block of the model.
+func (s *scratch) inputPlanes(n, width, height int) []ImagePlane {
+	if s == nil {
+		return newPlanes(nil, make([]float32, n*width*height), n, width, height)
+	}
+	s.input = grow(s.input, n*width*height)
+	s.planes[2] = newPlanes(s.planes[2], s.input, n, width, height)
+	s.next = 0
+	return s.planes[2]
+}
+
+// outputPlanes returns the planes for the output of a layer, whose values are undefined. They are backed by
+// the ping-pong buffers alternately, so that they do not overlap the input planes of the layer which are
+// the output of the previous one.
+func (s *scratch) outputPlanes(n, width, height int) []ImagePlane {
+	if s == nil {
+		return newPlanes(nil, make([]float32, n*width*height), n, width, height)
+	}
+	i := s.next
+	s.next ^= 1
+	s.buffers[i] = grow(s.buffers[i], n*width*height)
+	s.planes[i] = newPlanes(s.planes[i], s.buffers[i], n, width, height)
+	return s.planes[i]
+}
+
+// temp returns the i-th temporary buffer of the size, whose values are undefined.
+func (s *scratch) temp(i, size int) []float32 {
+	if s == nil {
+		return make([]float32, size)
+	}
+	s.temps[i] = grow(s.temps[i], size)
+	return s.temps[i]
+}
+
+// int16Temp returns the i-th temporary buffer of int16 of the size, whose values are undefined.
+func (s *scratch) int16Temp(i, size int) []int16 {
+	if s == nil {
+		return make([]int16, size)
+	}
+	if cap(s.int16s[i]) < size {
+		s.int16s[i] = make([]int16, size)
+	}
+	return s.int16s[i][:size]
+}
+
+// int32Temp returns the temporary buffer of int32 of the size, whose values are undefined.
+func (s *scratch) int32Temp(size int) []int32 {
+	if s == nil {
+		return make([]int32, size)
+	}
+	if cap(s.int32s) < size {
+		s.int32s = make([]int32, size)
+	}
+	return s.int32s[:size]
+}
+
+// planeSource holds the input planes of a model in float32 or in half precision.
+type planeSource struct {
+	full []ImagePlane
+	half []halfPlane
+}
+
+func newPlaneSource(planes []ImagePlane, half bool) planeSource {
+	if half {
+		return planeSource{half: newHalfPlanes(planes)}
+	}
+	return planeSource{full: planes}
+}
+
+// block returns the block of the size at (x, y) of the planes, which is backed by the scratch.
+func (p planeSource) block(s *scratch, x, y, width, height int) []ImagePlane {
+	n := len(p.full)
+	if p.half != nil {
+		n = len(p.half)
+	}
+	ret := s.inputPlanes(n, width, height)
+	t := halfToFloat32Table()
+	for i := range ret {
+		dst := ret[i].Buffer
+		for h := 0; h < height; h++ {
+			row := dst[h*width : (h+1)*width]
+			if p.half == nil {
+				src := p.full[i]
+				copy(row, src.Buffer[(y+h)*src.Width+x:])
+				continue
+			}
+			src := p.half[i]
+			for w, v := range src.Buffer[(y+h)*src.Width+x : (y+h)*src.Width+x+width] {
+				row[w] = t[v]
+			}
+		}
+	}
+	return ret
+}
+
+// blockGrid is the blocks which an image plane is divided into, see Blocking.
+type blockGrid struct {
+	width, height      int
+	blockSize, overlap int
+	blocksW, blocksH   int
+}
+
+func newBlockGrid(width, height, blockSize, overlap int) blockGrid {
+	stride := blockSize - overlap
+	return blockGrid{
+		width:     width,
+		height:    height,
+		blockSize: blockSize,
+		overlap:   overlap,
+		blocksW:   (width - overlap + stride - 1) / stride,
+		blocksH:   (height - overlap + stride - 1) / stride,
+	}
+}
+
+func (g blockGrid) blocks() int {
+	return g.blocksW * g.blocksH
+}
+
+// block returns the position and the size of the i-th block.
+func (g blockGrid) block(i int) (x, y, width, height int) {
+	stride := g.blockSize - g.overlap
+	x, y = i%g.blocksW*stride, i/g.blocksW*stride
+	width, height = g.blockSize, g.blockSize
+	if x+width > g.width {
+		width = g.width - x // right end block
+	}
+	if y+height > g.height {
+		height = g.height - y // bottom end block
+	}
+	return x, y, width, height
+}
@@ -0,0 +1,116 @@
+package engine
+
+import (
+	"fmt"
+	"image"
+	"math"
+)
+
+// Fit represents how an image is fitted to the target size, see Size.
+type Fit int
+
+const (
+	// Exact scales the image to the width and the height, the aspect ratio can change.
+	Exact Fit = iota + 1
+	// Within scales the image to the largest size within the width and the height keeping the aspect ratio.
+	Within
+	// Fill scales the image to the smallest size covering the width and the height keeping the aspect ratio,
+	// and crops the center of the width and the height.
+	Fill
+)
+
+// String returns the name of the fit.
+func (f Fit) String() string {
+	switch f {
+	case Exact:
+		return "exact"
+	case Within:
+		return "within"
+	case Fill:
+		return "fill"
+	}
+	return fmt.Sprintf("Fit(%d)", int(f))
+}
+
+// Size represents the size of the output image.
+// Either the width or the height can be 0 for Exact, then it is derived from the aspect ratio of the image.
+type Size struct {
+	Width  int
+	Height int
+	Fit    Fit
+}
+
+// resolve returns the size which the image of the width and the height is resampled to,
+// and the rectangle of the output cropped from it.
+func (s Size) resolve(width, height int) (int, int, image.Rectangle, error) {
+	if width < 1 || height < 1 {
+		return 0, 0, image.Rectangle{}, fmt.Errorf("empty image: %dx%d", width, height)
+	}
+	if err := s.validate(); err != nil {
+		return 0, 0, image.Rectangle{}, err
+	}
+	rx := float64(s.Width) / float64(width)
+	ry := float64(s.Height) / float64(height)
+	var w, h int
+	switch s.Fit {
+	case Exact:
+		w, h = s.Width, s.Height
+		if w == 0 {
+			w = int(math.Max(1, math.Round(float64(width)*ry)))
+		}
+		if h == 0 {
+			h = int(math.Max(1, math.Round(float64(height)*rx)))
+		}
+	case Within: // the side of the smaller ratio is the target, the other one does not exceed the target
+		w = clamp(int(math.Round(float64(width)*ry)), 1, s.Width)
+		h = clamp(int(math.Round(float64(height)*rx)), 1, s.Height)
+		if rx < ry {
+			w = s.Width
+		} else {
+			h = s.Height
+		}
+	case Fill: // the side of the larger ratio is the target, the other one is not less than the target
+		w = int(math.Max(float64(s.Width), math.Round(float64(width)*ry)))
+		h = int(math.Max(float64(s.Height), math.Round(float64(height)*rx)))
+		if rx > ry {
+			w = s.Width
+		} else {
+			h = s.Height
+		}
+		x, y := (w-s.Width)/2, (h-s.Height)/2
+		return w, h, image.Rect(x, y, x+s.Width, y+s.Height), nil
+	}
+	return w, h, image.Rect(0, 0, w, h), nil
+}
+
+func (s Size) validate() error {
+	if s.Fit < Exact || s.Fit > Fill {
+		return fmt.Errorf("unknown fit: %v", s.Fit)
+	}
+	if s.Width < 0 || s.Height < 0 || (s.Width == 0 && s.Height == 0) {
+		return fmt.Errorf("invalid size: %dx%d", s.Width, s.Height)
+	}
+	if s.Fit != Exact && (s.Width == 0 || s.Height == 0) {
+		return fmt.Errorf("%v requires both the width and the height, but %dx%d", s.Fit, s.Width, s.Height)
+	}
+	return nil
+}
+
+func clamp(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
+
+// scaleSize returns the size of the image of the width and the height scaled by the scale.
+func scaleSize(width, height int, scale float64) Size {
+	return Size{
+		Width:  int(math.Round(float64(width) * scale)),
+		Height: int(math.Round(float64(height) * scale)),
+		Fit:    Exact,
+	}
+}
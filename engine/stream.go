@@ -0,0 +1,160 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"math"
+)
+
+// RowWriter is the interface that receives rows of the output image in order from top to bottom.
+type RowWriter interface {
+	// WriteRows receives the rows starting at the row y of the output image.
+	// The rows are an RGBA channel image of the width of the output image,
+	// and its buffer must not be retained after the call returns.
+	WriteRows(y int, rows ChannelImage) error
+}
+
+// RowWriterFunc is an adapter to allow the use of ordinary functions as a RowWriter.
+type RowWriterFunc func(y int, rows ChannelImage) error
+
+// WriteRows calls f(y, rows).
+func (f RowWriterFunc) WriteRows(y int, rows ChannelImage) error {
+	return f(y, rows)
+}
+
+// BandHeight sets the option that specifies the number of input rows processed at once by ScaleUpStream.
+func BandHeight(rows int) Option {
+	return func(w *Waifu2x) error {
+		if rows < 1 {
+			return fmt.Errorf("band height must be >= 1, but %d", rows)
+		}
+		w.bandHeight = rows
+		return nil
+	}
+}
+
+// ScaleUpStream scales up the image band by band and writes the finished rows to the row writer.
+// Each band of input rows is processed together with the surrounding rows the models need,
+// so the output is the same as ScaleUp, while the peak memory is proportional to the band height
+// rather than to the image area. The scale must be a power of two.
+func (w Waifu2x) ScaleUpStream(ctx context.Context, img image.Image, scale float64, rw RowWriter) error {
+	r := img.Bounds()
+	if r.Empty() {
+		return fmt.Errorf("empty image: %v", r)
+	}
+	passes := scalePasses(scale)
+	factor := 1 << passes
+	if float64(factor) != scale || (passes > 0 && w.scaleModel == nil) {
+		return fmt.Errorf("stream scale up supports powers of two with the scale model only, but %v", scale)
+	}
+	bandHeight := w.bandHeight
+	if bandHeight == 0 {
+		bandHeight = w.blockSize()
+	}
+	opaque, err := isOpaqueImage(img, bandHeight)
+	if err != nil {
+		return err
+	}
+	margin := w.streamMargin(passes)
+	bands := int(math.Ceil(float64(r.Dy()) / float64(bandHeight)))
+
+	j := newJob(w.observers, w.parallel)
+	defer j.close()
+	j.bands = bands
+	for b := 0; b < bands; b++ {
+		y0 := b * bandHeight
+		y1 := y0 + bandHeight
+		if y1 > r.Dy() {
+			y1 = r.Dy()
+		}
+		// only the rows of the band and its margins are converted
+		c0, c1 := y0-margin, y1+margin
+		if c0 < 0 {
+			c0 = 0
+		}
+		if c1 > r.Dy() {
+			c1 = r.Dy()
+		}
+		j.band = b
+		band, err := w.scaleUpBand(ctx, j, subImage(img, image.Rect(r.Min.X, r.Min.Y+c0, r.Max.X, r.Min.Y+c1)), factor, opaque)
+		if err != nil {
+			return err
+		}
+		rows := cropRows(band, (y0-c0)*factor, (y1-c0)*factor)
+		if err := rw.WriteRows(y0*factor, rows.ChannelImage()); err != nil {
+			return fmt.Errorf("write rows error: %w", err)
+		}
+	}
+	return nil
+}
+
+// scaleUpBand scales up the band of the image by the factor, which is a power of two.
+func (w Waifu2x) scaleUpBand(ctx context.Context, j *job, img image.Image, factor int, opaque bool) (ChannelImage16, error) {
+	band, _, err := NewChannelImage16(img)
+	if err != nil {
+		return ChannelImage16{}, err
+	}
+	steps := w.planScale(band.Width, band.Height, band.Width*factor, band.Height*factor)
+	j.passes = len(steps)
+	for i, s := range steps {
+		if err := ctx.Err(); err != nil {
+			return ChannelImage16{}, err
+		}
+		j.pass = i + 1
+		band, err = w.convertChannelImage(ctx, j, band, opaque, s)
+		if err != nil {
+			return ChannelImage16{}, err
+		}
+	}
+	return band, nil
+}
+
+// boundedImage is the image whose bounds are restricted, for the images which have no SubImage method.
+type boundedImage struct {
+	image.Image
+	r image.Rectangle
+}
+
+// Bounds returns the restricted bounds.
+func (b boundedImage) Bounds() image.Rectangle {
+	return b.r
+}
+
+// subImage returns the image of the rectangle of the image, which shares the pixels with the image.
+func subImage(img image.Image, r image.Rectangle) image.Image {
+	if s, ok := img.(interface {
+		SubImage(r image.Rectangle) image.Image
+	}); ok {
+		return s.SubImage(r)
+	}
+	return boundedImage{Image: img, r: r.Intersect(img.Bounds())}
+}
+
+// isOpaqueImage reports whether the converted image is opaque as NewChannelImage16 does.
+// The image is converted band by band, so that the whole image is not allocated.
+func isOpaqueImage(img image.Image, bandHeight int) (bool, error) {
+	r := img.Bounds()
+	for y := r.Min.Y; y < r.Max.Y; y += bandHeight {
+		_, opaque, err := NewChannelImage16(subImage(img, image.Rect(r.Min.X, y, r.Max.X, y+bandHeight)))
+		if err != nil || !opaque {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// streamMargin returns the number of input rows around a band that affect the output of the band.
+func (w Waifu2x) streamMargin(passes int) int {
+	return (w.noiseModel.Overlap() + passes*w.scaleModel.Overlap()) / 2 // the noise model runs only once
+}
+
+// cropRows returns the rows [y0, y1) of the channel image. The buffer is shared with the original.
+func cropRows(img ChannelImage16, y0, y1 int) ChannelImage16 {
+	stride := len(img.Buffer) / img.Height
+	return ChannelImage16{
+		Width:  img.Width,
+		Height: y1 - y0,
+		Buffer: img.Buffer[y0*stride : y1*stride],
+	}
+}
@@ -0,0 +1,70 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"testing"
+)
+
+func TestWaifu2x_ScaleUpStream(t *testing.T) {
+	models := &ModelSet{
+		NoiseModel:   passThroughModel(1, []int{3, 8, 3}),
+		Scale2xModel: passThroughModel(2, []int{3, 8, 8, 3}),
+	}
+	img := loadTestImage(t, "../testdata/neko_alpha.png", 40, 56)
+	tests := []struct {
+		scale      float64
+		bandHeight int
+		img        image.Image
+		opts       []Option
+	}{
+		{scale: 2, bandHeight: 13, img: img},
+		{scale: 4, bandHeight: 9, img: img},
+		{scale: 4, bandHeight: 17, img: img, opts: []Option{ScaleAlpha(true)}},
+		{scale: 2, bandHeight: 11, img: struct{ image.Image }{img}}, // no SubImage method
+		{scale: 1, bandHeight: 5, img: img},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("scale=%v,band=%d", tt.scale, tt.bandHeight), func(t *testing.T) {
+			w2x, err := NewWaifu2xModelSet(models, append(tt.opts, BandHeight(tt.bandHeight))...)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			want, err := w2x.ScaleUp(context.TODO(), img, tt.scale)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var got []byte
+			var next int
+			err = w2x.ScaleUpStream(context.TODO(), tt.img, tt.scale, RowWriterFunc(func(y int, rows ChannelImage) error {
+				if y != next {
+					t.Errorf("want row %d, got %d", next, y)
+				}
+				if rows.Width != want.Width {
+					t.Errorf("want width %d, got %d", want.Width, rows.Width)
+				}
+				next += rows.Height
+				got = append(got, rows.Buffer...)
+				return nil
+			}))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if next != want.Height {
+				t.Errorf("want height %d, got %d", want.Height, next)
+			}
+			if !bytes.Equal(want.Buffer, got) {
+				t.Errorf("stream output differs from ScaleUp output")
+			}
+		})
+	}
+	w2x, err := NewWaifu2xModelSet(models)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := w2x.ScaleUpStream(context.TODO(), img, 3, RowWriterFunc(func(int, ChannelImage) error { return nil })); err == nil {
+		t.Errorf("expected error for a scale which is not a power of two")
+	}
+}
@@ -0,0 +1,96 @@
+package engine
+
+// Transform represents a flip or a rotation of an image, which is a combination of the flags.
+// The horizontal flip is applied first, the vertical flip next and the transposition last,
+// e.g. Transpose|FlipVertical rotates the image 90 degrees clockwise.
+type Transform int
+
+const (
+	// FlipHorizontal flips the image horizontally.
+	FlipHorizontal Transform = 1 << iota
+	// FlipVertical flips the image vertically.
+	FlipVertical
+	// Transpose swaps the x and y axes of the image.
+	Transpose
+)
+
+// Inverse returns the transform which restores the image transformed by the transform.
+func (t Transform) Inverse() Transform {
+	if t&Transpose == 0 {
+		return t
+	}
+	// the flips are swapped by the transposition
+	ret := t &^ (FlipHorizontal | FlipVertical)
+	if t&FlipHorizontal != 0 {
+		ret |= FlipVertical
+	}
+	if t&FlipVertical != 0 {
+		ret |= FlipHorizontal
+	}
+	return ret
+}
+
+// indices returns the size of the image of the width and the height transformed by the transform,
+// and the index of the source pixel of each pixel of it.
+func (t Transform) indices(width, height int) (int, int, []int) {
+	w, h := width, height
+	if t&Transpose != 0 {
+		w, h = height, width
+	}
+	ret := make([]int, 0, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			sx, sy := x, y
+			if t&Transpose != 0 {
+				sx, sy = y, x
+			}
+			if t&FlipHorizontal != 0 {
+				sx = width - 1 - sx
+			}
+			if t&FlipVertical != 0 {
+				sy = height - 1 - sy
+			}
+			ret = append(ret, sy*width+sx)
+		}
+	}
+	return w, h, ret
+}
+
+// Transform returns the image plane transformed by the transform.
+func (p ImagePlane) Transform(t Transform) ImagePlane {
+	w, h, indices := t.indices(p.Width, p.Height)
+	ret := NewImagePlaneWidthHeight(w, h)
+	for i, v := range indices {
+		ret.Buffer[i] = p.Buffer[v]
+	}
+	return ret
+}
+
+// Transform returns the channel image transformed by the transform.
+func (c ChannelImage) Transform(t Transform) ChannelImage {
+	n := len(c.Buffer) / (c.Width * c.Height) // samples per pixel
+	w, h, indices := t.indices(c.Width, c.Height)
+	ret := ChannelImage{
+		Width:  w,
+		Height: h,
+		Buffer: make([]uint8, len(c.Buffer)),
+	}
+	for i, v := range indices {
+		copy(ret.Buffer[i*n:(i+1)*n], c.Buffer[v*n:(v+1)*n])
+	}
+	return ret
+}
+
+func (c ChannelImage16) transform(t Transform) ChannelImage16 {
+	n := len(c.Buffer) / (c.Width * c.Height) // samples per pixel
+	w, h, indices := t.indices(c.Width, c.Height)
+	ret := ChannelImage16{
+		Width:  w,
+		Height: h,
+		Buffer: make([]uint16, len(c.Buffer)),
+	}
+	for i, v := range indices {
+		copy(ret.Buffer[i*n:(i+1)*n], c.Buffer[v*n:(v+1)*n])
+	}
+	return ret
+}
@@ -0,0 +1,48 @@
+package engine
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTransform(t *testing.T) {
+	// 0 1 2
+	// 3 4 5
+	img := ChannelImage{Width: 3, Height: 2, Buffer: []uint8{0, 1, 2, 3, 4, 5}}
+	tests := []struct {
+		t    Transform
+		w, h int
+		want []uint8
+	}{
+		{t: 0, w: 3, h: 2, want: []uint8{0, 1, 2, 3, 4, 5}},
+		{t: FlipHorizontal, w: 3, h: 2, want: []uint8{2, 1, 0, 5, 4, 3}},
+		{t: FlipVertical, w: 3, h: 2, want: []uint8{3, 4, 5, 0, 1, 2}},
+		{t: Transpose, w: 2, h: 3, want: []uint8{0, 3, 1, 4, 2, 5}},
+		{t: Transpose | FlipVertical, w: 2, h: 3, want: []uint8{3, 0, 4, 1, 5, 2}}, // 90 degrees clockwise
+	}
+	for _, tt := range tests {
+		got := img.Transform(tt.t)
+		if got.Width != tt.w || got.Height != tt.h || !reflect.DeepEqual(tt.want, got.Buffer) {
+			t.Errorf("transform %d: want %dx%d %v, got %dx%d %v", tt.t, tt.w, tt.h, tt.want, got.Width, got.Height, got.Buffer)
+		}
+	}
+}
+
+func TestTransform_Inverse(t *testing.T) {
+	p := NewImagePlaneWidthHeight(5, 3)
+	for i := range p.Buffer {
+		p.Buffer[i] = float32(i)
+	}
+	rgba := ChannelImage16{Width: 5, Height: 3, Buffer: make([]uint16, 5*3*4)}
+	for i := range rgba.Buffer {
+		rgba.Buffer[i] = uint16(i)
+	}
+	for tr := Transform(0); tr < 8; tr++ {
+		if got := p.Transform(tr).Transform(tr.Inverse()); !reflect.DeepEqual(p, got) {
+			t.Errorf("transform %d: want %v, got %v", tr, p, got)
+		}
+		if got := rgba.transform(tr).transform(tr.Inverse()); !reflect.DeepEqual(rgba, got) {
+			t.Errorf("transform %d: want %v, got %v", tr, rgba, got)
+		}
+	}
+}
@@ -4,12 +4,12 @@ import (
 	"context"
 	"fmt"
 	"image"
+	"image/color"
 	"image/gif"
 	"io"
 	"math"
 	"os"
 	"runtime"
-	"sync"
 )
 
 // Option represents an option of waifu2x.
@@ -26,6 +26,40 @@ func Parallel(p int) Option {
 	}
 }
 
+// TileSize sets the option that specifies the size of blocks which an image is divided into.
+// The size must be larger than the overlap of the models, see Model.Overlap.
+func TileSize(size int) Option {
+	return func(w *Waifu2x) error {
+		if size < 1 {
+			return fmt.Errorf("tile size must be >= 1, but %d", size)
+		}
+		w.tileSize = size
+		return nil
+	}
+}
+
+// ScaleAlpha sets the option that scales up the alpha channel with the scale model instead of resizing it simply.
+// It takes effect only for images which are not opaque.
+func ScaleAlpha(v bool) Option {
+	return func(w *Waifu2x) error {
+		w.scaleAlpha = v
+		return nil
+	}
+}
+
+// TTA sets the option of the test-time augmentation, which applies the models to the n flipped and rotated
+// variants of the image and averages the results restored. n is 1, 2, 4 or 8, and 1 disables it.
+// It improves the quality a little, but takes n times as long.
+func TTA(n int) Option {
+	return func(w *Waifu2x) error {
+		if n != 1 && n != 2 && n != 4 && n != 8 {
+			return fmt.Errorf("TTA must be 1, 2, 4 or 8, but %d", n)
+		}
+		w.tta = n
+		return nil
+	}
+}
+
 // Verbose sets the verbose option.
 func Verbose(v bool) Option {
 	return func(w *Waifu2x) error {
@@ -42,27 +76,71 @@ func LogOutput(w io.Writer) Option {
 	}
 }
 
+// ProgressObserver sets the option that adds a function receiving progress events.
+func ProgressObserver(f ProgressFunc) Option {
+	return func(w *Waifu2x) error {
+		if f == nil {
+			return fmt.Errorf("progress observer is nil")
+		}
+		w.observers = append(w.observers, f)
+		return nil
+	}
+}
+
 // Waifu2x is the main structure for executing the waifu2x algorithm.
 type Waifu2x struct {
-	scaleModel Model
-	noiseModel Model
-	parallel   int
-	verbose    bool
-	logOutput  io.Writer
+	scaleModel  Model
+	noiseModel  Model
+	parallel    int
+	verbose     bool
+	logOutput   io.Writer
+	observers   []ProgressFunc
+	bandHeight  int
+	tileSize    int
+	scaleAlpha  bool
+	tta         int
+	precision   Precision
+	calibration []image.Image
+	backend     Backend
 }
 
-// NewWaifu2x creates a Waifu2x structure.
+// NewWaifu2x creates a Waifu2x structure with the trained models of the mode.
+// The models are loaded through DefaultModelCache, and only in half precision if the options need no more.
 func NewWaifu2x(mode Mode, noise int, opts ...Option) (*Waifu2x, error) {
-	m, err := NewAssetModelSet(mode, noise)
+	ret, err := newWaifu2x(opts)
 	if err != nil {
 		return nil, err
 	}
+	dir, err := assetModelDir(mode, noise)
+	if err != nil {
+		return nil, err
+	}
+	load := loadCachedModelAssets
+	if ret.halfOnly() {
+		load = loadCachedHalfModelAssets
+	}
+	m, err := loadModelSet(load, dir, noise, true)
+	if err != nil {
+		return nil, err
+	}
+	return ret.setModelSet(m)
+}
+
+// NewWaifu2xModelSet creates a Waifu2x structure with the set of trained models, e.g. loaded by LoadModelSet.
+// Either the scale model or the noise model of the set can be nil, but not both.
+func NewWaifu2xModelSet(m *ModelSet, opts ...Option) (*Waifu2x, error) {
+	ret, err := newWaifu2x(opts)
+	if err != nil {
+		return nil, err
+	}
+	return ret.setModelSet(m)
+}
+
+func newWaifu2x(opts []Option) (*Waifu2x, error) {
 	ret := &Waifu2x{
-		scaleModel: m.Scale2xModel,
-		noiseModel: m.NoiseModel,
-		logOutput:  os.Stderr,
-		parallel:   runtime.GOMAXPROCS(runtime.NumCPU()),
-		verbose:    false,
+		logOutput: os.Stderr,
+		parallel:  runtime.GOMAXPROCS(runtime.NumCPU()),
+		verbose:   false,
 	}
 	for _, opt := range opts {
 		if err := opt(ret); err != nil {
@@ -72,194 +150,489 @@ func NewWaifu2x(mode Mode, noise int, opts ...Option) (*Waifu2x, error) {
 	return ret, nil
 }
 
-func (w Waifu2x) printf(format string, a ...interface{}) {
+// setModelSet sets the models prepared for the options.
+func (w *Waifu2x) setModelSet(m *ModelSet) (*Waifu2x, error) {
+	if m == nil {
+		return nil, fmt.Errorf("model set is nil")
+	}
+	if m.Scale2xModel == nil && m.NoiseModel == nil {
+		return nil, fmt.Errorf("model set has neither the scale model nor the noise model")
+	}
+	for _, v := range []Model{m.Scale2xModel, m.NoiseModel} {
+		for l, p := range v {
+			n := p.NInputPlane * p.NOutputPlane * p.KW * p.KH
+			if len(p.WeightVec) != n && len(p.weightHalf) != n {
+				return nil, fmt.Errorf("layer %d: weight vector is not prepared, load the model with LoadModel", l)
+			}
+		}
+	}
+	w.scaleModel = m.Scale2xModel
+	w.noiseModel = m.NoiseModel
+	if err := w.prepareModels(); err != nil {
+		return nil, err
+	}
+	for _, m := range []Model{w.scaleModel, w.noiseModel} {
+		if w.blockSize() <= m.Overlap() {
+			return nil, fmt.Errorf("tile size must be larger than the model overlap %d, but %d", m.Overlap(), w.blockSize())
+		}
+	}
 	if w.verbose {
-		fmt.Fprintf(w.logOutput, format, a...)
+		w.observers = append(w.observers, TerminalProgress(w.logOutput))
 	}
+	return w, nil
 }
 
-func (w Waifu2x) println(a ...interface{}) {
-	if w.verbose {
-		fmt.Fprintln(w.logOutput, a...)
+// halfOnly reports whether the options use the weights only in half precision.
+// The calibration runs the models in float32.
+func (w Waifu2x) halfOnly() bool {
+	return (w.precision == Float16Weights || w.precision == Float16) && len(w.calibration) == 0
+}
+
+// prepareModels replaces the models with the copies for the options of the inference.
+// The models themselves are left untouched since they may be shared, e.g. by the model cache,
+// so the methods preparing the weights return the copies. The prepared weights of a loaded model
+// are built once and shared by the copies, see Param.prepare.
+func (w *Waifu2x) prepareModels() error {
+	for _, m := range []*Model{&w.scaleModel, &w.noiseModel} {
+		if *m == nil {
+			continue
+		}
+		if len(w.calibration) > 0 {
+			q, err := QuantizeModel(*m, w.calibration)
+			if err != nil {
+				return fmt.Errorf("calibration error: %w", err)
+			}
+			*m = q
+		}
+		if w.precision == Float16Weights || w.precision == Float16 {
+			*m = m.halfWeights()
+		}
+		switch w.backend {
+		case GEMM:
+			*m = m.gemmWeights()
+		case Winograd:
+			*m = m.winogradWeights()
+		default:
+			*m = m.fmaWeights()
+		}
 	}
+	return nil
+}
+
+func (w Waifu2x) blockSize() int {
+	if w.tileSize == 0 {
+		return BlockSize
+	}
+	return w.tileSize
 }
 
 // ScaleUpGIF scales up the GIF image.
+// It stops as soon as the context is done and returns ctx.Err() in that case.
 func (w Waifu2x) ScaleUpGIF(ctx context.Context, img *gif.GIF, scale float64) (*gif.GIF, error) {
+	return w.ScaleUpGIFSize(ctx, img, scaleSize(img.Config.Width, img.Config.Height, scale))
+}
+
+// ScaleUpGIFSize scales up the GIF image to the size, which is resolved for the size of the GIF.
+// The frames at the offsets are placed at the scaled offsets.
+// It stops as soon as the context is done and returns ctx.Err() in that case.
+func (w Waifu2x) ScaleUpGIFSize(ctx context.Context, img *gif.GIF, size Size) (*gif.GIF, error) {
+	width, height, crop, err := size.resolve(img.Config.Width, img.Config.Height)
+	if err != nil {
+		return nil, err
+	}
+	j := newJob(w.observers, w.parallel)
+	defer j.close()
+	j.frames = len(img.Image)
 	frames := make([]*image.Paletted, 0, len(img.Image))
-	for _, v := range img.Image {
-		p := v.Palette
-		ci, err := w.ScaleUp(ctx, v, scale)
+	for i, v := range img.Image {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		j.frame = i
+		// the frame at the offset is scaled to the rectangle of the scaled image, and cropped by the rectangle
+		r := scaleRect(v.Bounds(), float64(width)/float64(img.Config.Width), float64(height)/float64(img.Config.Height))
+		visible := r.Intersect(crop)
+		if visible.Empty() {
+			frames = append(frames, transparentFrame(v.Palette))
+			continue
+		}
+		ci, err := w.scaleUp(ctx, j, v, r.Dx(), r.Dy(), visible.Sub(r.Min))
 		if err != nil {
 			return nil, err
 		}
-		ip := ci.ImagePaletted(p)
+		ip := ci.ChannelImage().ImagePaletted(v.Palette)
+		ip.Rect = ip.Rect.Add(visible.Min.Sub(crop.Min))
 		frames = append(frames, ip)
 	}
 	img.Image = frames
-	img.Config.Width = int(float64(img.Config.Width) * scale)
-	img.Config.Height = int(float64(img.Config.Height) * scale)
+	img.Config.Width = crop.Dx()
+	img.Config.Height = crop.Dy()
 	return img, nil
 }
 
+// scaleRect returns the rectangle scaled by the ratios, whose edges are rounded independently
+// so that the adjacent rectangles stay adjacent.
+func scaleRect(r image.Rectangle, sx, sy float64) image.Rectangle {
+	return image.Rect(
+		int(math.Round(float64(r.Min.X)*sx)), int(math.Round(float64(r.Min.Y)*sy)),
+		int(math.Round(float64(r.Max.X)*sx)), int(math.Round(float64(r.Max.Y)*sy)),
+	)
+}
+
+// transparentFrame returns the frame of a transparent pixel, which replaces the frame cropped entirely
+// since a GIF frame must not be empty. A transparent color is appended to the copy of the palette
+// if it has none, or the frame has its own palette of the transparent color if the palette is full.
+func transparentFrame(p color.Palette) *image.Paletted {
+	for i, c := range p {
+		if _, _, _, a := c.RGBA(); a == 0 {
+			ret := image.NewPaletted(image.Rect(0, 0, 1, 1), p)
+			ret.Pix[0] = uint8(i)
+			return ret
+		}
+	}
+	palette := color.Palette{color.Transparent}
+	if len(p) < 256 {
+		palette = append(append(make(color.Palette, 0, len(p)+1), p...), color.Transparent)
+	}
+	ret := image.NewPaletted(image.Rect(0, 0, 1, 1), palette)
+	ret.Pix[0] = uint8(len(palette) - 1)
+	return ret
+}
+
 // ScaleUp scales up the image.
+// It stops as soon as the context is done and returns ctx.Err() in that case.
 func (w Waifu2x) ScaleUp(ctx context.Context, img image.Image, scale float64) (ChannelImage, error) {
-	ci, _, err := NewChannelImage(img)
+	b := img.Bounds()
+	return w.ScaleUpSize(ctx, img, scaleSize(b.Dx(), b.Dy(), scale))
+}
+
+// ScaleUpSize scales up the image to the size, which is resolved for the size of the image.
+// The models run the minimum number of the 2x passes for the size, and the result is resampled to it.
+// The bounds of the image can start at any point, e.g. of a SubImage, and the result starts at (0, 0).
+// It stops as soon as the context is done and returns ctx.Err() in that case.
+func (w Waifu2x) ScaleUpSize(ctx context.Context, img image.Image, size Size) (ChannelImage, error) {
+	ci, err := w.ScaleUpSize16(ctx, img, size)
 	if err != nil {
 		return ChannelImage{}, err
 	}
-	for {
-		if scale < 2.0 {
-			ci, err = w.convertChannelImage(ctx, ci, scale)
-			if err != nil {
-				return ChannelImage{}, err
-			}
-			break
+	return ci.ChannelImage(), nil
+}
+
+// Denoise applies the noise model to the image without scaling, which is the same as ScaleUp by 1.
+// It returns an error if the noise model is not set.
+func (w Waifu2x) Denoise(ctx context.Context, img image.Image) (ChannelImage, error) {
+	ci, err := w.Denoise16(ctx, img)
+	if err != nil {
+		return ChannelImage{}, err
+	}
+	return ci.ChannelImage(), nil
+}
+
+// Denoise16 applies the noise model to the image keeping 16 bits per sample, see Denoise.
+func (w Waifu2x) Denoise16(ctx context.Context, img image.Image) (ChannelImage16, error) {
+	if w.noiseModel == nil {
+		return ChannelImage16{}, fmt.Errorf("noise model is not set")
+	}
+	return w.ScaleUp16(ctx, img, 1)
+}
+
+// ScaleUp16 scales up the image keeping 16 bits per sample, e.g. of image.NRGBA64, see ScaleUp.
+func (w Waifu2x) ScaleUp16(ctx context.Context, img image.Image, scale float64) (ChannelImage16, error) {
+	b := img.Bounds()
+	return w.ScaleUpSize16(ctx, img, scaleSize(b.Dx(), b.Dy(), scale))
+}
+
+// ScaleUpSize16 scales up the image to the size keeping 16 bits per sample, see ScaleUpSize.
+func (w Waifu2x) ScaleUpSize16(ctx context.Context, img image.Image, size Size) (ChannelImage16, error) {
+	b := img.Bounds()
+	width, height, crop, err := size.resolve(b.Dx(), b.Dy())
+	if err != nil {
+		return ChannelImage16{}, err
+	}
+	j := newJob(w.observers, w.parallel)
+	defer j.close()
+	return w.scaleUp(ctx, j, img, width, height, crop)
+}
+
+// scaleUp scales up the image to the width and the height, and crops the rectangle from it.
+func (w Waifu2x) scaleUp(ctx context.Context, j *job, img image.Image, width, height int, crop image.Rectangle) (ChannelImage16, error) {
+	ci, opaque, err := NewChannelImage16(img)
+	if err != nil {
+		return ChannelImage16{}, err
+	}
+	steps := w.planScale(ci.Width, ci.Height, width, height)
+	j.passes = len(steps)
+	for i, s := range steps {
+		if err := ctx.Err(); err != nil {
+			return ChannelImage16{}, err
 		}
-		ci, err = w.convertChannelImage(ctx, ci, 2)
+		j.pass = i + 1
+		ci, err = w.convertChannelImage(ctx, j, ci, opaque, s)
 		if err != nil {
-			return ChannelImage{}, err
+			return ChannelImage16{}, err
 		}
-		scale = scale / 2.0
 	}
-	return ci, err
+	return ci.crop(crop), nil
+}
+
+// scaleStep is a pass of the scale up.
+type scaleStep struct {
+	denoise       bool // apply the noise model
+	scale         bool // scale up 2x with the scale model
+	width, height int  // the size of the output, the result of the models is resampled to it
 }
 
-func (w Waifu2x) convertChannelImage(ctx context.Context, img ChannelImage, scale float64) (ChannelImage, error) {
-	if (w.scaleModel == nil && w.noiseModel == nil) || scale <= 1 {
-		return img, nil
+// planScale returns the passes which scale the image of the width and the height to the target size.
+// The scale model runs the minimum number of the 2x passes, the noise model runs only in the first pass,
+// and the last pass resamples the result to the exact size with the Lanczos filter.
+// The image of the same size is only de-noised.
+func (w Waifu2x) planScale(width, height, targetWidth, targetHeight int) []scaleStep {
+	if width == targetWidth && height == targetHeight && w.noiseModel == nil {
+		return nil
+	}
+	passes := 0
+	if w.scaleModel != nil {
+		for width<<passes < targetWidth || height<<passes < targetHeight {
+			passes++
+		}
+	}
+	if passes == 0 {
+		return []scaleStep{{denoise: w.noiseModel != nil, width: targetWidth, height: targetHeight}}
+	}
+	ret := make([]scaleStep, passes)
+	for i := range ret {
+		ret[i] = scaleStep{
+			denoise: i == 0 && w.noiseModel != nil,
+			scale:   true,
+			width:   width << (i + 1),
+			height:  height << (i + 1),
+		}
 	}
+	ret[passes-1].width, ret[passes-1].height = targetWidth, targetHeight
+	return ret
+}
 
-	if w.parallel > 0 {
-		w.printf("# of goroutines: %d\n", w.parallel)
+// scalePasses returns the minimum number of the 2x passes whose scale is not less than the scale.
+func scalePasses(scale float64) int {
+	n := 0
+	for s := 1.0; s < scale; s *= 2 {
+		n++
 	}
+	return n
+}
 
+func (w Waifu2x) convertChannelImage(ctx context.Context, j *job, img ChannelImage16, opaque bool, step scaleStep) (ChannelImage16, error) {
 	// decompose
-	w.println("decomposing channels ...")
-	r, g, b, a := ChannelDecompose(img)
+	j.startPhase(PhaseDecompose, 0)
+	r, g, b, a := channelDecompose16(img)
 
 	// de-noising
-	if w.noiseModel != nil {
-		w.println("de-noising ...")
+	if step.denoise {
 		var err error
-		r, g, b, err = w.convertRGB(ctx, r, g, b, w.noiseModel, 1)
+		r, g, b, err = w.convertColor(ctx, j, PhaseDenoise, r, g, b, w.noiseModel, 1)
 		if err != nil {
-			return ChannelImage{}, err
+			return ChannelImage16{}, err
 		}
 	}
 
 	// calculate
-	if w.scaleModel != nil {
-		w.println("scaling ...")
+	if step.scale {
 		var err error
-		r, g, b, err = w.convertRGB(ctx, r, g, b, w.scaleModel, scale)
+		r, g, b, err = w.convertColor(ctx, j, PhaseScale, r, g, b, w.scaleModel, 2)
 		if err != nil {
-			return ChannelImage{}, err
+			return ChannelImage16{}, err
 		}
-	}
 
-	// alpha channel
-	a = a.Resize(scale)
-	/*
-		if !opaque {
-			a = a.Resize(scale) // Resize simply
-		} else if w.scaleModel != nil { // upscale the alpha channel
-			w.println("scaling alpha ...")
-			var err error
-			a, _, _, err = w.convertRGB(ctx, a, a, a, w.scaleModel, scale)
+		// alpha channel
+		if opaque || !w.scaleAlpha {
+			a = a.resize(2) // Resize simply
+		} else { // upscale the alpha channel
+			in := []ChannelImage16{a}
+			if w.scaleModel.InputPlanes() == 3 {
+				in = []ChannelImage16{a, a, a}
+			}
+			out, err := w.convertChannels(ctx, j, PhaseScaleAlpha, in, w.scaleModel, 2)
 			if err != nil {
-				return ChannelImage{}, err
+				return ChannelImage16{}, err
 			}
+			a = out[0]
 		}
-	*/
-
-	if len(a.Buffer) != len(r.Buffer) {
-		return ChannelImage{}, fmt.Errorf("channel image size must be same, A=%d, R=%d", len(a.Buffer), len(r.Buffer))
 	}
 
+	// resample to the size of the step
+	j.startPhase(PhaseCompose, 0)
+	r = r.resizeLanczos(step.width, step.height)
+	g = g.resizeLanczos(step.width, step.height)
+	b = b.resizeLanczos(step.width, step.height)
+	a = a.resizeLanczos(step.width, step.height)
+
 	// recompose
-	w.println("composing channels ...")
-	return ChannelCompose(r, g, b, a), nil
+	return channelCompose16(r, g, b, a), nil
+}
+
+// ttaTransforms are the variants of the test-time augmentation, the first n of them are used.
+var ttaTransforms = []Transform{
+	0,
+	FlipHorizontal,
+	FlipVertical,
+	FlipHorizontal | FlipVertical,
+	Transpose,
+	Transpose | FlipHorizontal,
+	Transpose | FlipVertical,
+	Transpose | FlipHorizontal | FlipVertical,
+}
+
+// convertColor applies the model to the R, G and B channels.
+// With the test-time augmentation, the model is applied to the variants, and the restored results are averaged.
+func (w Waifu2x) convertColor(ctx context.Context, j *job, phase Phase, r, g, b ChannelImage16, model Model, scale float64) (ChannelImage16, ChannelImage16, ChannelImage16, error) {
+	if w.tta <= 1 {
+		return w.convertRGB(ctx, j, phase, r, g, b, model, scale)
+	}
+	var sums [3][]uint32
+	var ret [3]ChannelImage16
+	for _, t := range ttaTransforms[:w.tta] {
+		vr, vg, vb, err := w.convertRGB(ctx, j, phase, r.transform(t), g.transform(t), b.transform(t), model, scale)
+		if err != nil {
+			return ChannelImage16{}, ChannelImage16{}, ChannelImage16{}, err
+		}
+		for i, c := range []ChannelImage16{vr, vg, vb} {
+			ret[i] = c.transform(t.Inverse())
+			if sums[i] == nil {
+				sums[i] = make([]uint32, len(ret[i].Buffer))
+			}
+			for k, v := range ret[i].Buffer {
+				sums[i][k] += uint32(v)
+			}
+		}
+	}
+	n := uint32(w.tta)
+	for i := range ret { // the buffers of the last variant are reused
+		for k, v := range sums[i] {
+			ret[i].Buffer[k] = uint16((v + n/2) / n)
+		}
+	}
+	return ret[0], ret[1], ret[2], nil
 }
 
-func (w Waifu2x) convertRGB(_ context.Context, imageR, imageG, imageB ChannelImage, model Model, scale float64) (r, g, b ChannelImage, err error) {
-	var inputPlanes [3]ImagePlane
-	for i, img := range []ChannelImage{imageR, imageG, imageB} {
-		imgResized := img.Resize(scale)
-		imgExtra := imgResized.Extrapolation(len(model))
-		p, err := NewNormalizedImagePlane(imgExtra)
+// convertRGB applies the model to the R, G and B channels.
+// The model which takes the luminance only is applied to the Y channel in the YCbCr color space,
+// and the chroma channels are resized with the bicubic filter.
+func (w Waifu2x) convertRGB(ctx context.Context, j *job, phase Phase, r, g, b ChannelImage16, model Model, scale float64) (ChannelImage16, ChannelImage16, ChannelImage16, error) {
+	switch model.InputPlanes() {
+	case 3:
+		out, err := w.convertChannels(ctx, j, phase, []ChannelImage16{r, g, b}, model, scale)
 		if err != nil {
-			return ChannelImage{}, ChannelImage{}, ChannelImage{}, err
+			return ChannelImage16{}, ChannelImage16{}, ChannelImage16{}, err
 		}
-		inputPlanes[i] = p
+		return out[0], out[1], out[2], nil
+	case 1:
+		y, cb, cr := channelRGBToYCbCr16(r, g, b)
+		out, err := w.convertChannels(ctx, j, phase, []ChannelImage16{y}, model, scale)
+		if err != nil {
+			return ChannelImage16{}, ChannelImage16{}, ChannelImage16{}, err
+		}
+		y = out[0]
+		cb = cb.resizeBicubic(y.Width, y.Height)
+		cr = cr.resizeBicubic(y.Width, y.Height)
+		r, g, b = channelYCbCrToRGB16(y, cb, cr)
+		return r, g, b, nil
 	}
+	return ChannelImage16{}, ChannelImage16{}, ChannelImage16{}, fmt.Errorf("unsupported number of input planes of the model: %d", model.InputPlanes())
+}
 
-	// Blocking
-	inputBlocks, blocksW, blocksH := Blocking(inputPlanes)
+func (w Waifu2x) convertChannels(ctx context.Context, j *job, phase Phase, channels []ChannelImage16, model Model, scale float64) ([]ChannelImage16, error) {
+	if len(channels) != model.InputPlanes() {
+		return nil, fmt.Errorf("the model takes %d planes, but %d", model.InputPlanes(), len(channels))
+	}
+	// the model which has deconvolution layers scales up the image by itself,
+	// so the image is resized before the model only by the rest of the scale,
+	// or resized after the model if the scale is less than the one of the model.
+	pre := scale / float64(model.Scale())
+	post := 1.0
+	if pre < 1 {
+		pre, post = 1, pre
+	}
+	inputPlanes := make([]ImagePlane, len(channels))
+	for i, img := range channels {
+		inputPlanes[i] = img.resize(pre).extrapolation(model.Overlap() / 2).normalizedPlane()
+	}
 
-	// init W
-	outputBlocks := make([][]ImagePlane, len(inputBlocks))
+	src := newPlaneSource(inputPlanes, w.precision == Float16)
+	grid := newBlockGrid(inputPlanes[0].Width, inputPlanes[0].Height, w.blockSize(), model.Overlap())
+	a, b := model.geometry()
+	outputs := make([]ChannelImage16, len(channels))
+	for i := range outputs {
+		outputs[i] = newChannelImage16(a*inputPlanes[0].Width-b, a*inputPlanes[0].Height-b)
+	}
 
-	digits := int(math.Log10(float64(len(inputBlocks)))) + 2
-	fmtStr := fmt.Sprintf("%%%dd/%%%dd", digits, digits) + " (%.1f%%)"
-	w.printf(fmtStr, 0, len(inputBlocks), 0.0)
+	j.startPhase(phase, grid.blocks())
 
-	limit := make(chan struct{}, w.parallel)
-	wg := sync.WaitGroup{}
-	for i := range inputBlocks {
-		wg.Add(1)
-		go func(i int) {
-			limit <- struct{}{}
-			defer wg.Done()
-			if i >= 10 {
-				w.printf("\x1b[2K\r"+fmtStr, i+1, len(inputBlocks), float32(i+1)/float32(len(inputBlocks))*100)
-			}
-			inputBlock := inputBlocks[i]
-			var outputBlock []ImagePlane
-			for l := range model {
-				nOutputPlane := model[l].NOutputPlane
-				// convolution
-				outputBlock = convolution(inputBlock, model[l].WeightVec, nOutputPlane, model[l].Bias)
-				inputBlock = outputBlock // propagate output plane to next layer input
-				inputBlocks[i] = nil
+	j.run(ctx, grid.blocks(), func(i int, s *scratch) {
+		if ctx.Err() != nil {
+			return
+		}
+		x, y, width, height := grid.block(i)
+		block := src.block(s, x, y, width, height)
+		for l := range model {
+			if ctx.Err() != nil {
+				return
 			}
-			outputBlocks[i] = outputBlock
-			<-limit
-		}(i)
-	}
-	wg.Wait()
+			block = forward(model[l], block, s) // propagate output plane to next layer input
+		}
+		// the output blocks do not overlap each other
+		for c := range outputs {
+			outputs[c].setDenormalized(a*x, a*y, block[c])
+		}
+		j.blockDone()
+	})
 
-	w.println()
-	inputBlocks = nil
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	for i := range outputs {
+		outputs[i] = outputs[i].resize(post)
+	}
+	return outputs, nil
+}
 
-	// de-blocking
-	outputPlanes := Deblocking(outputBlocks, blocksW, blocksH)
-	R := NewDenormalizedChannelImage(outputPlanes[0])
-	G := NewDenormalizedChannelImage(outputPlanes[1])
-	B := NewDenormalizedChannelImage(outputPlanes[2])
-	return R, G, B, nil
+// forward applies the layer to the input planes.
+// The output planes are backed by the scratch, which can be nil.
+func forward(p Param, inputPlanes []ImagePlane, s *scratch) []ImagePlane {
+	if p.Type() == Deconvolution {
+		return deconvolution(inputPlanes, p.weights(s), p.NOutputPlane, p.Bias, p.KW, p.stride(), p.PadW, s)
+	}
+	if p.weightWinograd != nil {
+		return convolutionWinograd(inputPlanes, p.weightWinograd, p.NOutputPlane, p.Bias, s)
+	}
+	if p.weightGEMM != nil {
+		return convolutionGEMM(inputPlanes, p.weightGEMM, p.NOutputPlane, p.Bias, p.KW, s)
+	}
+	if p.KW == 3 {
+		if p.weightInt8 != nil {
+			return convolutionInt8(inputPlanes, p.weightInt8, p.NOutputPlane, p.Bias, s)
+		}
+		if p.weightHalf != nil {
+			return convolutionHalf(inputPlanes, p.weightHalf, p.NOutputPlane, p.Bias, s)
+		}
+		if p.weightFMA != nil {
+			return convolutionFMA(inputPlanes, p.weightFMA, p.NOutputPlane, p.Bias, s)
+		}
+		return convolution(inputPlanes, p.WeightVec, p.NOutputPlane, p.Bias, s)
+	}
+	return convolutionKernel(inputPlanes, p.weights(s), p.NOutputPlane, p.Bias, p.KW, s)
 }
 
-func convolution(inputPlanes []ImagePlane, W []float32, nOutputPlane int, bias []float32) []ImagePlane {
+func convolution(inputPlanes []ImagePlane, W []float32, nOutputPlane int, bias []float32, s *scratch) []ImagePlane {
 	if len(inputPlanes) == 0 {
 		return nil
 	}
 	width := inputPlanes[0].Width
 	height := inputPlanes[0].Height
-	outputPlanes := make([]ImagePlane, nOutputPlane)
-	for i := 0; i < nOutputPlane; i++ {
-		outputPlanes[i] = NewImagePlaneWidthHeight(width-2, height-2)
-	}
-	sumValues := make([]float32, nOutputPlane)
-	biasValues := make([]float32, nOutputPlane)
-	for i := 0; i < nOutputPlane; i++ {
-		biasValues[i] = bias[i]
-	}
+	outputPlanes := s.outputPlanes(nOutputPlane, width-2, height-2)
+	sumValues := s.temp(0, nOutputPlane)
 	for y := 1; y < height-1; y++ {
 		for x := 1; x < width-1; x++ {
-			for i := range biasValues {
-				sumValues[i] = biasValues[i]
-			}
+			copy(sumValues, bias)
 			const square = 9
 			wi := 0
 			for i := range inputPlanes {
@@ -284,3 +657,103 @@ func convolution(inputPlanes []ImagePlane, W []float32, nOutputPlane int, bias [
 	}
 	return outputPlanes
 }
+
+// convolutionKernel is the convolution of the k x k kernel, where k is odd.
+func convolutionKernel(inputPlanes []ImagePlane, W []float32, nOutputPlane int, bias []float32, k int, s *scratch) []ImagePlane {
+	if len(inputPlanes) == 0 {
+		return nil
+	}
+	width := inputPlanes[0].Width - (k - 1)
+	height := inputPlanes[0].Height - (k - 1)
+	outputPlanes := s.outputPlanes(nOutputPlane, width, height)
+	square := k * k
+	sumValues := s.temp(0, nOutputPlane)
+	segment := s.temp(1, square)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			copy(sumValues, bias)
+			wi := 0
+			for i := range inputPlanes {
+				p := inputPlanes[i]
+				for ky := 0; ky < k; ky++ {
+					j := x + (y+ky)*p.Width
+					copy(segment[ky*k:(ky+1)*k], p.Buffer[j:j+k])
+				}
+				for o := 0; o < nOutputPlane; o++ {
+					ws := W[wi : wi+square]
+					v := sumValues[o]
+					for s, a := range segment {
+						v += ws[s] * a
+					}
+					sumValues[o] = v
+					wi += square
+				}
+			}
+			for o := 0; o < nOutputPlane; o++ {
+				v := sumValues[o]
+				if v < 0 {
+					v *= 0.1
+				}
+				outputPlanes[o].SetAt(x, y, v)
+			}
+		}
+	}
+	return outputPlanes
+}
+
+// deconvolution is the transposed convolution of the k x k kernel with the stride and the padding.
+func deconvolution(inputPlanes []ImagePlane, W []float32, nOutputPlane int, bias []float32, k, stride, pad int, s *scratch) []ImagePlane {
+	if len(inputPlanes) == 0 {
+		return nil
+	}
+	width := (inputPlanes[0].Width-1)*stride - 2*pad + k
+	height := (inputPlanes[0].Height-1)*stride - 2*pad + k
+	outputPlanes := s.outputPlanes(nOutputPlane, width, height)
+	for _, p := range outputPlanes {
+		for j := range p.Buffer {
+			p.Buffer[j] = 0
+		}
+	}
+	square := k * k
+	// scatter each input pixel to the output planes
+	for i := range inputPlanes {
+		p := inputPlanes[i]
+		for y := 0; y < p.Height; y++ {
+			for x := 0; x < p.Width; x++ {
+				v := p.Value(x, y)
+				for o := 0; o < nOutputPlane; o++ {
+					ws := W[(i*nOutputPlane+o)*square : (i*nOutputPlane+o+1)*square]
+					scatter(outputPlanes[o], v, ws, x*stride-pad, y*stride-pad, k)
+				}
+			}
+		}
+	}
+	for o := 0; o < nOutputPlane; o++ {
+		out := outputPlanes[o].Buffer
+		for j := range out {
+			v := out[j] + bias[o]
+			if v < 0 {
+				v *= 0.1
+			}
+			out[j] = v
+		}
+	}
+	return outputPlanes
+}
+
+// scatter adds the k x k kernel multiplied by the value to the plane at (x0, y0), clipped to the plane.
+func scatter(p ImagePlane, v float32, ws []float32, x0, y0, k int) {
+	for ky := 0; ky < k; ky++ {
+		oy := y0 + ky
+		if oy < 0 || oy >= p.Height {
+			continue
+		}
+		for kx := 0; kx < k; kx++ {
+			ox := x0 + kx
+			if ox < 0 || ox >= p.Width {
+				continue
+			}
+			p.Buffer[ox+oy*p.Width] += v * ws[ky*k+kx]
+		}
+	}
+}
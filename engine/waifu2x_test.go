@@ -1,14 +1,22 @@
 package engine
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
 	"image/png"
 	"math"
+	"math/rand"
 	"os"
+	"reflect"
 	"runtime"
 	"testing"
+	"time"
 )
 
 func TestWaifu2x_ScaleUp(t *testing.T) {
@@ -50,6 +58,828 @@ func TestWaifu2x_ScaleUp(t *testing.T) {
 	}
 }
 
+func TestWaifu2x_planScale(t *testing.T) {
+	scale := Model{{}}
+	noise := Model{{}}
+	testdata := []struct {
+		name          string
+		w2x           Waifu2x
+		width, height int
+		want          []scaleStep
+	}{
+		{
+			name: "same size", w2x: Waifu2x{scaleModel: scale, noiseModel: noise}, width: 10, height: 20,
+			want: []scaleStep{{denoise: true, width: 10, height: 20}},
+		},
+		{name: "same size without noise model", w2x: Waifu2x{scaleModel: scale}, width: 10, height: 20},
+		{
+			name: "x1.7", w2x: Waifu2x{scaleModel: scale, noiseModel: noise}, width: 17, height: 34,
+			want: []scaleStep{{denoise: true, scale: true, width: 17, height: 34}},
+		},
+		{
+			name: "x3.3", w2x: Waifu2x{scaleModel: scale, noiseModel: noise}, width: 33, height: 66,
+			want: []scaleStep{
+				{denoise: true, scale: true, width: 20, height: 40},
+				{scale: true, width: 33, height: 66},
+			},
+		},
+		{
+			name: "x4.0 without noise model", w2x: Waifu2x{scaleModel: scale}, width: 40, height: 80,
+			want: []scaleStep{
+				{scale: true, width: 20, height: 40},
+				{scale: true, width: 40, height: 80},
+			},
+		},
+		{
+			name: "only the height is larger", w2x: Waifu2x{scaleModel: scale}, width: 10, height: 50,
+			want: []scaleStep{
+				{scale: true, width: 20, height: 40},
+				{scale: true, width: 10, height: 50},
+			},
+		},
+		{
+			name: "x1.5 without scale model", w2x: Waifu2x{noiseModel: noise}, width: 15, height: 30,
+			want: []scaleStep{{denoise: true, width: 15, height: 30}},
+		},
+		{
+			name: "smaller", w2x: Waifu2x{scaleModel: scale}, width: 5, height: 10,
+			want: []scaleStep{{width: 5, height: 10}},
+		},
+	}
+	for _, tt := range testdata {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.w2x.planScale(10, 20, tt.width, tt.height); !reflect.DeepEqual(tt.want, got) {
+				t.Errorf("want %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestWaifu2x_ScaleUpSize(t *testing.T) {
+	w2x, err := NewWaifu2xModelSet(&ModelSet{Scale2xModel: randomModel(1, []int{3, 4, 3}, []int{3, 3})})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	img := loadTestImage(t, "../testdata/neko_small.png", 30, 20)
+	testdata := []struct {
+		size          Size
+		width, height int
+	}{
+		{size: Size{Width: 100, Height: 40, Fit: Exact}, width: 100, height: 40},
+		{size: Size{Width: 45, Fit: Exact}, width: 45, height: 30},
+		{size: Size{Height: 50, Fit: Exact}, width: 75, height: 50},
+		{size: Size{Width: 100, Height: 100, Fit: Within}, width: 100, height: 67},
+		{size: Size{Width: 100, Height: 100, Fit: Fill}, width: 100, height: 100},
+		{size: Size{Width: 20, Height: 50, Fit: Fill}, width: 20, height: 50},
+	}
+	for _, tt := range testdata {
+		t.Run(fmt.Sprintf("%v %dx%d", tt.size.Fit, tt.size.Width, tt.size.Height), func(t *testing.T) {
+			got, err := w2x.ScaleUpSize(context.TODO(), img, tt.size)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got.Width != tt.width || got.Height != tt.height || len(got.Buffer) != tt.width*tt.height*4 {
+				t.Errorf("want %dx%d, got %dx%d (%d bytes)", tt.width, tt.height, got.Width, got.Height, len(got.Buffer))
+			}
+		})
+	}
+	for _, size := range []Size{{Width: 10, Height: 10}, {Fit: Exact}, {Width: 10, Fit: Within}, {Width: -1, Height: 10, Fit: Exact}} {
+		if _, err := w2x.ScaleUpSize(context.TODO(), img, size); err == nil {
+			t.Errorf("%+v: expected error", size)
+		}
+	}
+}
+
+func TestSize_resolve(t *testing.T) {
+	// 300x200 in a 100x100 box
+	width, height, crop, err := Size{Width: 100, Height: 100, Fit: Fill}.resolve(300, 200)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if width != 150 || height != 100 || crop != image.Rect(25, 0, 125, 100) {
+		t.Errorf("want 150x100 cropped to (25,0)-(125,100), got %dx%d cropped to %v", width, height, crop)
+	}
+	width, height, crop, err = Size{Width: 100, Height: 100, Fit: Within}.resolve(300, 200)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if width != 100 || height != 67 || crop != image.Rect(0, 0, 100, 67) {
+		t.Errorf("want 100x67, got %dx%d cropped to %v", width, height, crop)
+	}
+}
+
+func TestChannelImage_ResizeLanczos(t *testing.T) {
+	img := NewChannelImageWidthHeight(8, 8)
+	for i := range img.Buffer {
+		img.Buffer[i] = uint8(i % 8 * 32)
+	}
+	// the nearest neighbor 2x image is resampled back to the original
+	got := img.Resize(2).ResizeLanczos(8, 8)
+	for i := range img.Buffer {
+		if d := int(img.Buffer[i]) - int(got.Buffer[i]); d < -16 || d > 16 {
+			t.Fatalf("index %d: want %d, got %d", i, img.Buffer[i], got.Buffer[i])
+		}
+	}
+}
+
+func TestWaifu2x_ScaleUp_SubImage(t *testing.T) {
+	w2x, err := NewWaifu2xModelSet(&ModelSet{Scale2xModel: randomModel(1, []int{3, 4, 3}, []int{3, 3})})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	img := loadTestImage(t, "../testdata/neko_small.png", 40, 30)
+	r := image.Rect(7, 5, 31, 22)
+	for _, v := range randomImages(2, img.Bounds()) {
+		sub, ok := v.(interface {
+			SubImage(r image.Rectangle) image.Image
+		})
+		if !ok {
+			t.Fatalf("%T has no SubImage", v)
+		}
+		// the crop copied to the origin in the same depth
+		var cp draw.Image = image.NewRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+		switch v.(type) {
+		case *image.RGBA64, *image.NRGBA64, *image.Gray16:
+			cp = image.NewRGBA64(cp.Bounds())
+		}
+		draw.Draw(cp, cp.Bounds(), v, r.Min, draw.Src)
+		want, err := w2x.ScaleUp(context.TODO(), cp, 2)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		got, err := w2x.ScaleUp(context.TODO(), sub.SubImage(r), 2)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got.Width != 2*r.Dx() || got.Height != 2*r.Dy() || !bytes.Equal(want.Buffer, got.Buffer) {
+			t.Errorf("%T: the sub image differs from the crop", v)
+		}
+	}
+}
+
+func TestWaifu2x_ScaleUpGIF_FrameOffset(t *testing.T) {
+	w2x, err := NewWaifu2xModelSet(&ModelSet{Scale2xModel: randomModel(1, []int{3, 4, 3}, []int{3, 3})})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	p := color.Palette{color.Transparent, color.Black, color.White}
+	frame := func(r image.Rectangle) *image.Paletted {
+		ret := image.NewPaletted(r, p)
+		for i := range ret.Pix {
+			ret.Pix[i] = uint8(1 + i%2)
+		}
+		return ret
+	}
+	newGIF := func() *gif.GIF {
+		return &gif.GIF{
+			Image:  []*image.Paletted{frame(image.Rect(0, 0, 16, 12)), frame(image.Rect(4, 6, 10, 12)), frame(image.Rect(0, 0, 3, 4))},
+			Delay:  []int{10, 10, 10},
+			Config: image.Config{ColorModel: p, Width: 16, Height: 12},
+		}
+	}
+	testdata := []struct {
+		name   string
+		size   Size
+		width  int
+		height int
+		frames []image.Rectangle
+	}{
+		{
+			name: "x2", size: Size{Width: 32, Height: 24, Fit: Exact}, width: 32, height: 24,
+			frames: []image.Rectangle{image.Rect(0, 0, 32, 24), image.Rect(8, 12, 20, 24), image.Rect(0, 0, 6, 8)},
+		},
+		{
+			name: "fill", size: Size{Width: 24, Height: 24, Fit: Fill}, width: 24, height: 24,
+			frames: []image.Rectangle{image.Rect(0, 0, 24, 24), image.Rect(4, 12, 16, 24), image.Rect(0, 0, 2, 8)},
+		},
+		{
+			name: "cropped entirely", size: Size{Width: 8, Height: 24, Fit: Fill}, width: 8, height: 24,
+			frames: []image.Rectangle{image.Rect(0, 0, 8, 24), image.Rect(0, 12, 8, 24), image.Rect(0, 0, 1, 1)},
+		},
+	}
+	for _, tt := range testdata {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := w2x.ScaleUpGIFSize(context.TODO(), newGIF(), tt.size)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got.Config.Width != tt.width || got.Config.Height != tt.height {
+				t.Errorf("want %dx%d, got %dx%d", tt.width, tt.height, got.Config.Width, got.Config.Height)
+			}
+			for i, f := range got.Image {
+				if f.Bounds() != tt.frames[i] {
+					t.Errorf("frame %d: want %v, got %v", i, tt.frames[i], f.Bounds())
+				}
+			}
+			var buf bytes.Buffer
+			if err := gif.EncodeAll(&buf, got); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func Test_transparentFrame(t *testing.T) {
+	full := make(color.Palette, 256)
+	for i := range full {
+		full[i] = color.Gray{Y: uint8(i)}
+	}
+	testdata := []struct {
+		name    string
+		palette color.Palette
+		size    int // the size of the palette of the frame
+	}{
+		{name: "transparent", palette: color.Palette{color.Black, color.Transparent, color.White}, size: 3},
+		{name: "opaque", palette: append(make(color.Palette, 0, 3), color.Black, color.White), size: 3},
+		{name: "full", palette: full, size: 1},
+	}
+	for _, tt := range testdata {
+		t.Run(tt.name, func(t *testing.T) {
+			got := transparentFrame(tt.palette)
+			if _, _, _, a := got.At(0, 0).RGBA(); a != 0 {
+				t.Errorf("want a transparent pixel, got %v", got.At(0, 0))
+			}
+			if len(got.Palette) != tt.size {
+				t.Errorf("want %d colors, got %d", tt.size, len(got.Palette))
+			}
+			for _, c := range tt.palette[len(tt.palette):cap(tt.palette)] {
+				if c != nil {
+					t.Errorf("the palette must not be modified")
+				}
+			}
+			var buf bytes.Buffer
+			if err := gif.EncodeAll(&buf, &gif.GIF{Image: []*image.Paletted{got}, Delay: []int{0}}); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestWaifu2x_ScaleUp16(t *testing.T) {
+	// the model which passes the input through exactly
+	m := randomModel(1, []int{3, 3}, []int{3})
+	for o := range m[0].Weight {
+		m[0].Bias[o] = 0
+		for i := range m[0].Weight[o] {
+			for y := range m[0].Weight[o][i] {
+				for x := range m[0].Weight[o][i][y] {
+					m[0].Weight[o][i][y][x] = 0
+				}
+			}
+		}
+		m[0].Weight[o][o][1][1] = 1
+	}
+	m.setWeightVec()
+	w2x, err := NewWaifu2xModelSet(&ModelSet{Scale2xModel: m})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	img := image.NewNRGBA64(image.Rect(0, 0, 16, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 16; x++ {
+			v := uint16(x*4099 + y*13 + 1)
+			img.SetNRGBA64(x, y, color.NRGBA64{R: v, G: ^v, B: v / 3, A: 0xffff})
+		}
+	}
+	ci, err := w2x.ScaleUp16(context.TODO(), img, 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got := ci.ImageNRGBA64()
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 16; x++ {
+			if want, got := img.NRGBA64At(x, y), got.NRGBA64At(2*x, 2*y); want != got {
+				t.Fatalf("(%d, %d): want %v, got %v", x, y, want, got)
+			}
+		}
+	}
+}
+
+func TestWaifu2x_TTA(t *testing.T) {
+	models := &ModelSet{
+		NoiseModel:   passThroughModel(1, []int{3, 8, 3}),
+		Scale2xModel: passThroughModel(2, []int{3, 8, 3}),
+	}
+	img := loadTestImage(t, "../testdata/neko_small.png", 30, 20)
+	scaleUp := func(opts ...Option) ChannelImage {
+		t.Helper()
+		w2x, err := NewWaifu2xModelSet(models, opts...)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		ci, err := w2x.ScaleUp(context.TODO(), img, 2)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return ci
+	}
+	base := scaleUp()
+	for _, n := range []int{2, 4, 8} {
+		t.Run(fmt.Sprintf("TTA(%d)", n), func(t *testing.T) {
+			got := scaleUp(TTA(n), Parallel(4))
+			if got.Width != 60 || got.Height != 40 {
+				t.Fatalf("want 60x40, got %dx%d", got.Width, got.Height)
+			}
+			if again := scaleUp(TTA(n), Parallel(1)); !reflect.DeepEqual(got, again) {
+				t.Errorf("output is not deterministic")
+			}
+			if reflect.DeepEqual(base, got) {
+				t.Errorf("output is the same as without TTA")
+			}
+		})
+	}
+	if _, err := NewWaifu2xModelSet(models, TTA(3)); err == nil {
+		t.Errorf("expected error for TTA(3)")
+	}
+}
+
+func TestWaifu2x_Denoise(t *testing.T) {
+	var phases []Phase
+	w2x, err := NewWaifu2xModelSet(&ModelSet{
+		NoiseModel:   passThroughModel(1, []int{3, 8, 3}),
+		Scale2xModel: randomModel(2, []int{3, 4, 3}, []int{3, 3}),
+	}, ProgressObserver(func(p Progress) {
+		if len(phases) == 0 || phases[len(phases)-1] != p.Phase {
+			phases = append(phases, p.Phase)
+		}
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	img := loadTestImage(t, "../testdata/neko_small.png", 30, 20)
+	got, err := w2x.Denoise(context.TODO(), img)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.Width != 30 || got.Height != 20 {
+		t.Errorf("want 30x20, got %dx%d", got.Width, got.Height)
+	}
+	if want := []Phase{PhaseDecompose, PhaseDenoise, PhaseCompose}; !reflect.DeepEqual(want, phases) {
+		t.Errorf("want %v, got %v", want, phases)
+	}
+
+	w2x, err = NewWaifu2xModelSet(&ModelSet{Scale2xModel: randomModel(2, []int{3, 4, 3}, []int{3, 3})})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := w2x.Denoise(context.TODO(), img); err == nil {
+		t.Errorf("expected error without the noise model")
+	}
+}
+
+func TestWaifu2x_ScaleUp_Canceled(t *testing.T) {
+	w2x, err := NewWaifu2x(Anime, 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	fp, err := os.Open("../testdata/neko_small.png")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer fp.Close()
+	img, err := png.Decode(fp)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := w2x.ScaleUp(ctx, img, 2.0); !errors.Is(err, context.Canceled) {
+		t.Errorf("want %v, got %v", context.Canceled, err)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	if _, err := w2x.ScaleUp(ctx, img, 4.0); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("want %v, got %v", context.DeadlineExceeded, err)
+	}
+}
+
+func TestWaifu2x_ProgressObserver(t *testing.T) {
+	var events []Progress
+	var log bytes.Buffer
+	w2x, err := NewWaifu2x(Anime, 1, Parallel(3), LogOutput(&log), ProgressObserver(func(p Progress) {
+		events = append(events, p)
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	img := loadTestImage(t, "../testdata/neko_small.png", 48, 48)
+	if _, err := w2x.ScaleUp(context.TODO(), img, 4.0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var phases []Phase
+	for i, e := range events {
+		if i == 0 || e.Phase != events[i-1].Phase {
+			phases = append(phases, e.Phase)
+		}
+		if e.Passes != 2 || e.Frames != 1 || e.Workers != 3 {
+			t.Errorf("unexpected passes, frames or workers: %+v", e)
+		}
+		if e.BlocksDone > e.BlocksTotal {
+			t.Errorf("blocks done exceeds total: %+v", e)
+		}
+	}
+	want := []Phase{
+		PhaseDecompose, PhaseDenoise, PhaseScale, PhaseCompose,
+		PhaseDecompose, PhaseScale, PhaseCompose, // de-noised only once
+	}
+	if !reflect.DeepEqual(want, phases) {
+		t.Errorf("want %v, got %v", want, phases)
+	}
+	if last := events[len(events)-1]; last.Fraction() != 1 {
+		t.Errorf("want fraction 1, got %v", last.Fraction())
+	}
+	if log.Len() != 0 {
+		t.Errorf("want no log output without Verbose, got %q", log.String())
+	}
+}
+
+func TestWaifu2x_TileSize(t *testing.T) {
+	img := loadTestImage(t, "../testdata/neko_small.png", 24, 72)
+	var want []byte
+	for _, size := range []int{128, 48, 24} {
+		w2x, err := NewWaifu2x(Anime, 0, TileSize(size))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		got, err := w2x.ScaleUp(context.TODO(), img, 2)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if want == nil {
+			want = got.Buffer
+		} else if !bytes.Equal(want, got.Buffer) {
+			t.Errorf("tile size %d: output differs from the default tile size", size)
+		}
+	}
+	if _, err := NewWaifu2x(Anime, 0, TileSize(14)); err == nil {
+		t.Errorf("expected error for a tile size not larger than the overlap")
+	}
+}
+
+func TestWaifu2x_ScaleAlpha(t *testing.T) {
+	img := loadTestImage(t, "../testdata/neko_alpha.png", 32, 32)
+	var alpha [2][]byte
+	for i, v := range []bool{false, true} {
+		w2x, err := NewWaifu2x(Anime, 0, ScaleAlpha(v))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		got, err := w2x.ScaleUp(context.TODO(), img, 2)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if want, got := 64, got.Width; want != got {
+			t.Errorf("want %d, got %d", want, got)
+		}
+		_, _, _, a := ChannelDecompose(got)
+		alpha[i] = a.Buffer
+	}
+	if bytes.Equal(alpha[0], alpha[1]) {
+		t.Errorf("expected the alpha channel scaled by the model differs from the resized one")
+	}
+}
+
+func TestInferenceOptions(t *testing.T) {
+	img := loadTestImage(t, "../testdata/neko_small.png", 32, 48)
+	passThrough := &ModelSet{
+		NoiseModel:   passThroughModel(1, []int{3, 16, 16, 3}),
+		Scale2xModel: passThroughModel(2, []int{3, 16, 32, 16, 3}),
+	}
+	upconv := &ModelSet{
+		NoiseModel:   passThroughModel(1, []int{3, 16, 16, 3}),
+		Scale2xModel: randomUpconvModel(2),
+	}
+	kernels := &ModelSet{
+		NoiseModel:   passThroughModel(1, []int{3, 16, 16, 3}),
+		Scale2xModel: randomModel(2, []int{3, 8, 8, 3}, []int{3, 5, 3}),
+	}
+	anime, err := NewAssetModelSet(Anime, 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tests := []struct {
+		name     string
+		set      *ModelSet
+		opt      Option
+		maxDiff  int     // the max difference of the bytes from the output without the option
+		meanDiff float64 // the mean difference of the bytes
+		minPSNR  float64 // the min PSNR in dB
+	}{
+		{name: "Float16Weights", set: passThrough, opt: InferencePrecision(Float16Weights), maxDiff: 2, meanDiff: 0.1},
+		{name: "Float16", set: passThrough, opt: InferencePrecision(Float16), maxDiff: 2, meanDiff: 0.1},
+		{name: "Float16Weights of Anime", set: anime, opt: InferencePrecision(Float16Weights), maxDiff: 2, meanDiff: 0.1},
+		{name: "Float16 of Anime", set: anime, opt: InferencePrecision(Float16), maxDiff: 2, meanDiff: 0.1},
+		{name: "GEMM", set: upconv, opt: ConvolutionBackend(GEMM), maxDiff: 1, meanDiff: 1},
+		{name: "Winograd", set: kernels, opt: ConvolutionBackend(Winograd), maxDiff: 1, meanDiff: 1},
+		{name: "Int8", set: passThrough, opt: Int8Calibration(img), maxDiff: 64, meanDiff: 8, minPSNR: 30},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := scaleUpWith(t, tt.set, img)
+			got := scaleUpWith(t, tt.set, img, tt.opt)
+			maxDiff, meanDiff := compareOutputs(t, want, got)
+			if maxDiff > tt.maxDiff {
+				t.Errorf("the max difference is %d", maxDiff)
+			}
+			if meanDiff > tt.meanDiff {
+				t.Errorf("the mean difference is %v", meanDiff)
+			}
+			if psnr, err := PSNR(want, got); err != nil || psnr < tt.minPSNR {
+				t.Errorf("the PSNR is %.2f dB, %v", psnr, err)
+			}
+			for _, m := range []Model{tt.set.NoiseModel, tt.set.Scale2xModel} {
+				for l, p := range m {
+					if p.WeightVec == nil || p.weightHalf != nil || p.weightInt8 != nil ||
+						p.weightGEMM != nil || p.weightWinograd != nil || p.weightFMA != nil {
+						t.Errorf("layer %d: the model set must not be modified", l)
+					}
+				}
+			}
+		})
+	}
+	if _, err := NewWaifu2xModelSet(passThrough, InferencePrecision(0)); err == nil {
+		t.Errorf("expected error for an unknown precision")
+	}
+	if _, err := NewWaifu2xModelSet(passThrough, ConvolutionBackend(0)); err == nil {
+		t.Errorf("expected error for an unknown backend")
+	}
+}
+
+// scaleUpWith returns the image scaled up twice with the models and the options.
+func scaleUpWith(t *testing.T, set *ModelSet, img image.Image, opts ...Option) ChannelImage {
+	t.Helper()
+	w2x, err := NewWaifu2xModelSet(set, append(opts, TileSize(24))...)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ret, err := w2x.ScaleUp(context.TODO(), img, 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return ret
+}
+
+// compareOutputs returns the max and the mean absolute differences of the bytes of the images.
+func compareOutputs(t *testing.T, want, got ChannelImage) (int, float64) {
+	t.Helper()
+	if len(want.Buffer) != len(got.Buffer) {
+		t.Fatalf("want %d bytes, got %d", len(want.Buffer), len(got.Buffer))
+	}
+	var maxDiff, sumDiff int
+	for i := range want.Buffer {
+		d := int(want.Buffer[i]) - int(got.Buffer[i])
+		if d < 0 {
+			d = -d
+		}
+		if d > maxDiff {
+			maxDiff = d
+		}
+		sumDiff += d
+	}
+	return maxDiff, float64(sumDiff) / float64(len(want.Buffer))
+}
+
+func TestNewAssetModelSet_NoNoiseModel(t *testing.T) {
+	if _, err := NewAssetModelSet(UKBench, 1); err == nil {
+		t.Errorf("expected error for the noise level of the mode without noise models")
+	}
+}
+
+func TestNewAssetNoiseModelSet(t *testing.T) {
+	m, err := NewAssetNoiseModelSet(Anime, 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if m.Scale2xModel != nil || m.NoiseModel == nil {
+		t.Errorf("expected the noise model only")
+	}
+	if _, err := NewAssetNoiseModelSet(Anime, 0); err == nil {
+		t.Errorf("expected error for the noise level 0")
+	}
+	if _, err := NewAssetNoiseModelSet(UKBench, 1); err == nil {
+		t.Errorf("expected error for the mode without noise models")
+	}
+}
+
+func TestNewWaifu2xModelSet_Empty(t *testing.T) {
+	if _, err := NewWaifu2xModelSet(&ModelSet{}); err == nil {
+		t.Errorf("expected error for the model set without models")
+	}
+}
+
+func Test_convolutionKernel(t *testing.T) {
+	m := randomModel(1, []int{3, 8, 3}, []int{3, 3})
+	in := randomPlanes(2, 3, 17, 13)
+	want := convolution(in, m[0].WeightVec, m[0].NOutputPlane, m[0].Bias, nil)
+	got := convolutionKernel(in, m[0].WeightVec, m[0].NOutputPlane, m[0].Bias, 3, nil)
+	assertPlanesNear(t, want, got, 1e-6)
+}
+
+func TestWaifu2x_KernelSizes(t *testing.T) {
+	scale := randomModel(1, []int{3, 8, 8, 3}, []int{5, 1, 3})
+	if want, got := 6, scale.Overlap(); want != got {
+		t.Errorf("want overlap %d, got %d", want, got)
+	}
+	w2x, err := NewWaifu2xModelSet(&ModelSet{Scale2xModel: scale}, TileSize(16))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	img := loadTestImage(t, "../testdata/neko_small.png", 20, 30)
+	got, err := w2x.ScaleUp(context.TODO(), img, 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.Width != 40 || got.Height != 60 {
+		t.Errorf("want 40x60, got %dx%d", got.Width, got.Height)
+	}
+	// the output must not depend on the tile size
+	w2x, err = NewWaifu2xModelSet(&ModelSet{Scale2xModel: scale})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want, err := w2x.ScaleUp(context.TODO(), img, 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(want.Buffer, got.Buffer) {
+		t.Errorf("output differs between tile sizes")
+	}
+}
+
+// randomModel returns a model with random weights of the planes and the kernel sizes of the layers.
+func randomModel(seed int64, planes []int, kernels []int) Model {
+	r := rand.New(rand.NewSource(seed))
+	m := make(Model, len(kernels))
+	for l, k := range kernels {
+		m[l] = randomParam(r, planes[l], planes[l+1], k)
+	}
+	m.setWeightVec()
+	return m
+}
+
+// passThroughModel returns a model of 3x3 kernels with random weights which roughly passes the input through,
+// so that the output is neither flat nor saturated.
+func passThroughModel(seed int64, planes []int) Model {
+	kernels := make([]int, len(planes)-1)
+	for i := range kernels {
+		kernels[i] = 3
+	}
+	m := randomModel(seed, planes, kernels)
+	for l := range m {
+		for o := range m[l].Weight {
+			m[l].Weight[o][o%m[l].NInputPlane][1][1] += 1
+		}
+	}
+	m.setWeightVec()
+	return m
+}
+
+// randomParam returns a convolution layer with random weights.
+func randomParam(r *rand.Rand, nInputPlane, nOutputPlane, k int) Param {
+	p := Param{
+		KW:           k,
+		KH:           k,
+		NInputPlane:  nInputPlane,
+		NOutputPlane: nOutputPlane,
+		Bias:         make([]float32, nOutputPlane),
+		Weight:       make([][][][]float32, nOutputPlane),
+	}
+	for o := range p.Weight {
+		p.Bias[o] = float32(r.NormFloat64() * 0.01)
+		p.Weight[o] = make([][][]float32, nInputPlane)
+		for i := range p.Weight[o] {
+			p.Weight[o][i] = make([][]float32, k)
+			for y := range p.Weight[o][i] {
+				p.Weight[o][i][y] = make([]float32, k)
+				for x := range p.Weight[o][i][y] {
+					p.Weight[o][i][y][x] = float32(r.NormFloat64() / float64(nInputPlane*k*k))
+				}
+			}
+		}
+	}
+	return p
+}
+
+// randomUpconvModel returns a model of the upconv style, 3x3 convolutions followed by a 4x4 stride 2 deconvolution.
+func randomUpconvModel(seed int64) Model {
+	r := rand.New(rand.NewSource(seed))
+	m := Model{randomParam(r, 3, 8, 3), randomParam(r, 8, 8, 3)}
+	deconv := randomParam(r, 3, 8, 4) // [nInputPlane][nOutputPlane][kH][kW]
+	deconv.ClassName = deconvolutionClassName
+	deconv.NInputPlane, deconv.NOutputPlane = 8, 3
+	deconv.DW, deconv.DH, deconv.PadW, deconv.PadH = 2, 2, 3, 3
+	deconv.Bias = deconv.Bias[:3]
+	m = append(m, deconv)
+	m.setWeightVec()
+	return m
+}
+
+func TestWaifu2x_Upconv(t *testing.T) {
+	scale := randomUpconvModel(1)
+	if err := scale.validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want, got := 2, scale.Scale(); want != got {
+		t.Errorf("want scale %d, got %d", want, got)
+	}
+	if want, got := 6, scale.Overlap(); want != got {
+		t.Errorf("want overlap %d, got %d", want, got)
+	}
+	img := loadTestImage(t, "../testdata/neko_small.png", 20, 30)
+	var want []byte
+	for _, size := range []int{128, 12} {
+		w2x, err := NewWaifu2xModelSet(&ModelSet{Scale2xModel: scale}, TileSize(size))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		got, err := w2x.ScaleUp(context.TODO(), img, 2)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got.Width != 40 || got.Height != 60 {
+			t.Errorf("want 40x60, got %dx%d", got.Width, got.Height)
+		}
+		if want == nil {
+			want = got.Buffer
+		} else if !bytes.Equal(want, got.Buffer) {
+			t.Errorf("tile size %d: output differs from the default tile size", size)
+		}
+		got, err = w2x.ScaleUp(context.TODO(), img, 1.5)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got.Width != 30 || got.Height != 45 {
+			t.Errorf("want 30x45, got %dx%d", got.Width, got.Height)
+		}
+	}
+}
+
+func Test_deconvolution(t *testing.T) {
+	// a single pixel spreads the kernel over the output with the stride
+	in := []ImagePlane{NewImagePlaneWidthHeight(2, 1)}
+	in[0].Buffer[1] = 1
+	W := []float32{1, 2, 3, 4, 5, 6, 7, 8, 9}
+	got := deconvolution(in, W, 1, []float32{0}, 3, 2, 1, nil)
+	want := []ImagePlane{{Width: 3, Height: 1, Buffer: []float32{0, 4, 5}}}
+	assertPlanesNear(t, want, got, 0)
+	// the output planes of the scratch are not zeroed
+	s := &scratch{}
+	for _, p := range append(s.outputPlanes(1, 3, 1), s.outputPlanes(1, 3, 1)...) {
+		for j := range p.Buffer {
+			p.Buffer[j] = 7
+		}
+	}
+	got = deconvolution(in, W, 1, []float32{0}, 3, 2, 1, s)
+	assertPlanesNear(t, want, got, 0)
+}
+
+// randomPlanes returns image planes with random values in [0, 1).
+func randomPlanes(seed int64, n, width, height int) []ImagePlane {
+	r := rand.New(rand.NewSource(seed))
+	ret := make([]ImagePlane, n)
+	for i := range ret {
+		ret[i] = NewImagePlaneWidthHeight(width, height)
+		for j := range ret[i].Buffer {
+			ret[i].Buffer[j] = r.Float32()
+		}
+	}
+	return ret
+}
+
+func assertPlanesNear(t *testing.T, want, got []ImagePlane, tolerance float64) {
+	t.Helper()
+	if len(want) != len(got) {
+		t.Fatalf("want %d planes, got %d", len(want), len(got))
+	}
+	for i := range want {
+		if want[i].Width != got[i].Width || want[i].Height != got[i].Height {
+			t.Fatalf("plane %d: want %dx%d, got %dx%d", i, want[i].Width, want[i].Height, got[i].Width, got[i].Height)
+		}
+		for j := range want[i].Buffer {
+			if d := math.Abs(float64(want[i].Buffer[j] - got[i].Buffer[j])); d > tolerance {
+				t.Fatalf("plane %d, index %d: want %v, got %v", i, j, want[i].Buffer[j], got[i].Buffer[j])
+			}
+		}
+	}
+}
+
+// loadTestImage loads the test image and crops the top left corner of the specified size.
+func loadTestImage(t testing.TB, path string, width, height int) *image.NRGBA {
+	t.Helper()
+	fp, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("failed to open the image (%s): %s", path, err)
+	}
+	defer fp.Close()
+	img, err := png.Decode(fp)
+	if err != nil {
+		t.Fatalf("failed to decode the image (%s): %s", path, err)
+	}
+	ret := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(ret, ret.Bounds(), img, img.Bounds().Min, draw.Src)
+	return ret
+}
+
 func BenchmarkWaifu(b *testing.B) {
 	tests := []struct {
 		name  string
@@ -120,19 +950,23 @@ func BenchmarkWaifu(b *testing.B) {
 
 		b.Run(tt.name, func(b *testing.B) {
 			w2x := Waifu2x{
-				scaleModel: model2x,
-				noiseModel: noise,
+				scaleModel: model2x.fmaWeights(),
+				noiseModel: noise.fmaWeights(),
 				parallel:   runtime.NumCPU(),
 			}
 
-			b.ResetTimer()
 			img := ChannelImage{
 				Width:  rgba.Bounds().Max.X,
 				Height: rgba.Bounds().Max.Y,
 				Buffer: rgba.Pix,
 			}
-			if _, err := w2x.convertChannelImage(context.TODO(), img, 2); err != nil {
-				b.Errorf("unexpected error: %v", err)
+			step := w2x.planScale(img.Width, img.Height, 2*img.Width, 2*img.Height)[0]
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := w2x.convertChannelImage(context.TODO(), nil, img.channelImage16(), false, step); err != nil {
+					b.Errorf("unexpected error: %v", err)
+				}
 			}
 		})
 	}
@@ -184,6 +1018,31 @@ func TestAllCombinations(t *testing.T) {
 			mode:  Photo,
 			noise: 3,
 		},
+		{
+			name:  "AnimeY, noiseModel reduction level 0",
+			mode:  AnimeY,
+			noise: 0,
+		},
+		{
+			name:  "AnimeY, noiseModel reduction level 1",
+			mode:  AnimeY,
+			noise: 1,
+		},
+		{
+			name:  "AnimeY, noiseModel reduction level 2",
+			mode:  AnimeY,
+			noise: 2,
+		},
+		{
+			name:  "AnimeY, noiseModel reduction level 3",
+			mode:  AnimeY,
+			noise: 3,
+		},
+		{
+			name:  "UKBench, noiseModel reduction level 0",
+			mode:  UKBench,
+			noise: 0,
+		},
 	}
 
 	fn := "../testdata/neko_alpha.png"
@@ -211,6 +1070,10 @@ func TestAllCombinations(t *testing.T) {
 				modelDir = "anime_style_art_rgb"
 			case Photo:
 				modelDir = "photo"
+			case AnimeY:
+				modelDir = "anime_style_art"
+			case UKBench:
+				modelDir = "ukbench"
 			}
 
 			scaleFn = fmt.Sprintf("model/%s/scale2.0x_model.json", modelDir)
@@ -232,8 +1095,8 @@ func TestAllCombinations(t *testing.T) {
 			}
 
 			w2x := Waifu2x{
-				scaleModel: model2x,
-				noiseModel: noise,
+				scaleModel: model2x.fmaWeights(),
+				noiseModel: noise.fmaWeights(),
 				parallel:   runtime.NumCPU(),
 			}
 			img := ChannelImage{
@@ -241,7 +1104,8 @@ func TestAllCombinations(t *testing.T) {
 				Width:  rgba.Bounds().Max.X,
 				Height: rgba.Bounds().Max.Y,
 			}
-			if _, err := w2x.convertChannelImage(context.TODO(), img, 2); err != nil {
+			step := w2x.planScale(img.Width, img.Height, 2*img.Width, 2*img.Height)[0]
+			if _, err := w2x.convertChannelImage(context.TODO(), nil, img.channelImage16(), false, step); err != nil {
 				t.Errorf("unexpected error: %v", err)
 			}
 		})
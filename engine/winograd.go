@@ -0,0 +1,140 @@
+package engine
+
+// winogradTiles is the number of the 2x2 output tiles transformed at once.
+const winogradTiles = 64
+
+// winogradWeights returns the copy of the model whose 3x3 convolution layers have the weights
+// transformed for the Winograd backend instead of WeightVec. The other layers are kept for the direct convolution.
+func (m Model) winogradWeights() Model {
+	if m == nil {
+		return nil
+	}
+	ret := make(Model, len(m))
+	for l, p := range m {
+		if p.Type() == Convolution && p.KW == 3 && p.WeightVec != nil && p.weightInt8 == nil {
+			p.weightWinograd = p.prepare(preparedWinograd, func() interface{} {
+				return transformWinogradWeights(p.WeightVec, p.NInputPlane, p.NOutputPlane)
+			}).([]float32)
+			p.WeightVec = nil
+			p.Weight = nil
+		}
+		ret[l] = p
+	}
+	return ret
+}
+
+// transformWinogradWeights transforms the 3x3 kernels [nInputPlane][nOutputPlane][9] to G g G^T,
+// and lays them out [16][nOutputPlane][nInputPlane] so that each of the 16 elements is a matrix multiplication.
+func transformWinogradWeights(w []float32, nInputPlane, nOutputPlane int) []float32 {
+	ret := make([]float32, 16*nOutputPlane*nInputPlane)
+	for i := 0; i < nInputPlane; i++ {
+		for o := 0; o < nOutputPlane; o++ {
+			g := w[(i*nOutputPlane+o)*9 : (i*nOutputPlane+o+1)*9]
+			// G = [[1, 0, 0], [1/2, 1/2, 1/2], [1/2, -1/2, 1/2], [0, 0, 1]]
+			var t [4][3]float32 // G g
+			for x := 0; x < 3; x++ {
+				g0, g1, g2 := g[x], g[3+x], g[6+x]
+				t[0][x] = g0
+				t[1][x] = (g0 + g1 + g2) / 2
+				t[2][x] = (g0 - g1 + g2) / 2
+				t[3][x] = g2
+			}
+			for y := 0; y < 4; y++ {
+				g0, g1, g2 := t[y][0], t[y][1], t[y][2]
+				u := [4]float32{g0, (g0 + g1 + g2) / 2, (g0 - g1 + g2) / 2, g2} // (G g) G^T
+				for x, v := range u {
+					ret[((y*4+x)*nOutputPlane+o)*nInputPlane+i] = v
+				}
+			}
+		}
+	}
+	return ret
+}
+
+// convolutionWinograd is the 3x3 convolution with the Winograd minimal filtering F(2x2,3x3),
+// the weights are transformed by transformWinogradWeights.
+func convolutionWinograd(inputPlanes []ImagePlane, U []float32, nOutputPlane int, bias []float32, s *scratch) []ImagePlane {
+	if len(inputPlanes) == 0 {
+		return nil
+	}
+	nInputPlane := len(inputPlanes)
+	width := inputPlanes[0].Width - 2
+	height := inputPlanes[0].Height - 2
+	outputPlanes := s.outputPlanes(nOutputPlane, width, height)
+	tilesW := (width + 1) / 2
+	tiles := tilesW * ((height + 1) / 2)
+	V := s.temp(0, 16*nInputPlane*winogradTiles)  // [16][nInputPlane][tiles]
+	M := s.temp(1, 16*nOutputPlane*winogradTiles) // [16][nOutputPlane][tiles]
+	for t0 := 0; t0 < tiles; t0 += winogradTiles {
+		n := winogradTiles
+		if t0+n > tiles {
+			n = tiles - t0
+		}
+		for i, p := range inputPlanes {
+			for t := 0; t < n; t++ {
+				x, y := (t0+t)%tilesW*2, (t0+t)/tilesW*2
+				transformWinogradInput(V[i*n+t:], nInputPlane*n, p, x, y)
+			}
+		}
+		for j := range M[:16*nOutputPlane*n] {
+			M[j] = 0
+		}
+		for e := 0; e < 16; e++ {
+			gemmAccumulate(M[e*nOutputPlane*n:(e+1)*nOutputPlane*n], n, U[e*nOutputPlane*nInputPlane:(e+1)*nOutputPlane*nInputPlane], nInputPlane, V[e*nInputPlane*n:(e+1)*nInputPlane*n], n, nOutputPlane, nInputPlane, n)
+		}
+		for o := range outputPlanes {
+			for t := 0; t < n; t++ {
+				x, y := (t0+t)%tilesW*2, (t0+t)/tilesW*2
+				transformWinogradOutput(&outputPlanes[o], M[o*n+t:], nOutputPlane*n, bias[o], x, y)
+			}
+		}
+	}
+	return outputPlanes
+}
+
+// transformWinogradInput transforms the 4x4 input tile at (x, y) to B^T d B, and stores the 16 elements
+// to v[0], v[stride], ..., v[15*stride]. The pixels out of the plane are 0.
+func transformWinogradInput(v []float32, stride int, p ImagePlane, x, y int) {
+	var d [4][4]float32
+	for dy := 0; dy < 4 && y+dy < p.Height; dy++ {
+		for dx := 0; dx < 4 && x+dx < p.Width; dx++ {
+			d[dy][dx] = p.Buffer[(y+dy)*p.Width+x+dx]
+		}
+	}
+	// B^T = [[1, 0, -1, 0], [0, 1, 1, 0], [0, -1, 1, 0], [0, 1, 0, -1]]
+	var t [4][4]float32 // B^T d
+	for c := 0; c < 4; c++ {
+		t[0][c] = d[0][c] - d[2][c]
+		t[1][c] = d[1][c] + d[2][c]
+		t[2][c] = d[2][c] - d[1][c]
+		t[3][c] = d[1][c] - d[3][c]
+	}
+	for r := 0; r < 4; r++ {
+		v[(r*4+0)*stride] = t[r][0] - t[r][2]
+		v[(r*4+1)*stride] = t[r][1] + t[r][2]
+		v[(r*4+2)*stride] = t[r][2] - t[r][1]
+		v[(r*4+3)*stride] = t[r][1] - t[r][3]
+	}
+}
+
+// transformWinogradOutput transforms the 16 elements m[0], m[stride], ..., m[15*stride] to the 2x2 output tile A^T m A,
+// and sets it with the bias and the activation at (x, y) of the plane.
+func transformWinogradOutput(p *ImagePlane, m []float32, stride int, bias float32, x, y int) {
+	// A^T = [[1, 1, 1, 0], [0, 1, -1, -1]]
+	var t [2][4]float32 // A^T m
+	for c := 0; c < 4; c++ {
+		m0, m1, m2, m3 := m[c*stride], m[(4+c)*stride], m[(8+c)*stride], m[(12+c)*stride]
+		t[0][c] = m0 + m1 + m2
+		t[1][c] = m1 - m2 - m3
+	}
+	for r := 0; r < 2 && y+r < p.Height; r++ {
+		out := [2]float32{t[r][0] + t[r][1] + t[r][2], t[r][1] - t[r][2] - t[r][3]}
+		for c := 0; c < 2 && x+c < p.Width; c++ {
+			v := out[c] + bias
+			if v < 0 {
+				v *= 0.1
+			}
+			p.Buffer[(y+r)*p.Width+x+c] = v
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package engine
+
+import (
+	"testing"
+)
+
+func Test_convolutionWinograd(t *testing.T) {
+	testdata := []struct {
+		name          string
+		planes        []int
+		width, height int
+	}{
+		{name: "even", planes: []int{3, 8}, width: 18, height: 12},
+		{name: "odd", planes: []int{3, 8}, width: 17, height: 13},
+		{name: "wide", planes: []int{64, 37}, width: 80, height: 9}, // more tiles than transformed at once
+		{name: "single pixel", planes: []int{2, 5}, width: 3, height: 3},
+	}
+	for _, v := range testdata {
+		t.Run(v.name, func(t *testing.T) {
+			m := randomModel(1, v.planes, []int{3})
+			in := randomPlanes(2, v.planes[0], v.width, v.height)
+			want := convolution(in, m[0].WeightVec, m[0].NOutputPlane, m[0].Bias, nil)
+			U := transformWinogradWeights(m[0].WeightVec, m[0].NInputPlane, m[0].NOutputPlane)
+			got := convolutionWinograd(in, U, m[0].NOutputPlane, m[0].Bias, nil)
+			assertPlanesNear(t, want, got, 1e-5)
+		})
+	}
+}